@@ -0,0 +1,25 @@
+// Package clock abstracts the time, so the expiry and timeout logic of the SDK can be tested deterministically
+package clock
+
+import "time"
+
+// Clock provides the current time and the timers
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// After returns the channel receiving the current time once the duration elapses
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real the Clock backed by the system time
+type Real struct{}
+
+// Now returns the current system time
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// After waits for the duration to elapse using the system timer
+func (Real) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
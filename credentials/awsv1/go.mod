@@ -0,0 +1,16 @@
+module github.com/kuzemkon/aws-iot-device-sdk-go/credentials/awsv1
+
+go 1.18
+
+require (
+	github.com/aws/aws-sdk-go v1.44.0
+	github.com/kuzemkon/aws-iot-device-sdk-go v0.0.0
+	github.com/stretchr/testify v1.3.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+)
+
+replace github.com/kuzemkon/aws-iot-device-sdk-go => ../..
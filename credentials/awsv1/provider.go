@@ -0,0 +1,60 @@
+// Package awsv1 adapts the AWS IoT credentials provider to the aws-sdk-go (v1) credentials, so the AWS service clients
+// can be authorized with the credentials retrieved by the device certificate. It's a separate module, so the aws-sdk-go
+// module is required only by the applications importing it rather than by the SDK module
+package awsv1
+
+import (
+	"time"
+
+	awscredentials "github.com/aws/aws-sdk-go/aws/credentials"
+
+	"github.com/kuzemkon/aws-iot-device-sdk-go/credentials"
+)
+
+// ProviderName the name of the provider reported in the retrieved credentials value
+const ProviderName = "AWSIoTCredentialsProvider"
+
+// Provider implements the aws-sdk-go credentials.Provider retrieving the credentials with the AWS IoT credentials
+// Service. The credentials are considered expired the refresh window before their actual expiration, so they're
+// refreshed in advance
+type Provider struct {
+	awscredentials.Expiry
+
+	service       credentials.Service
+	refreshWindow time.Duration
+}
+
+// NewProvider returns a new instance of the Provider retrieving the credentials with the service
+func NewProvider(service credentials.Service, refreshWindow time.Duration) *Provider {
+	return &Provider{
+		service:       service,
+		refreshWindow: refreshWindow,
+	}
+}
+
+// NewCredentials returns the aws-sdk-go Credentials caching the credentials retrieved by the Provider until they're
+// about to expire, e.g. to be passed to aws.Config
+func NewCredentials(service credentials.Service, refreshWindow time.Duration) *awscredentials.Credentials {
+	return awscredentials.NewCredentials(NewProvider(service, refreshWindow))
+}
+
+// Retrieve retrieves the credentials with the service and tracks their expiration
+func (p *Provider) Retrieve() (awscredentials.Value, error) {
+	out, err := p.service.GetCredentials()
+	if err != nil {
+		return awscredentials.Value{ProviderName: ProviderName}, err
+	}
+
+	expiresAt, err := out.ExpiresAt()
+	if err != nil {
+		return awscredentials.Value{ProviderName: ProviderName}, err
+	}
+	p.SetExpiration(expiresAt, p.refreshWindow)
+
+	return awscredentials.Value{
+		AccessKeyID:     out.AccessKeyId,
+		SecretAccessKey: out.SecretAccessKey,
+		SessionToken:    out.SessionToken,
+		ProviderName:    ProviderName,
+	}, nil
+}
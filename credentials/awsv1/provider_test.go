@@ -0,0 +1,51 @@
+package awsv1
+
+import (
+	"github.com/kuzemkon/aws-iot-device-sdk-go/credentials"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+
+	awscredentials "github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+var thingName = ""
+var url = ""
+var certPath = "../certificates/cert.pem"
+var privateKeyPath = "../certificates/private.key"
+
+func TestMain(m *testing.M) {
+	var ok bool
+
+	thingName, ok = os.LookupEnv("AWS_IOT_THING_NAME")
+	if !ok {
+		panic("AWS_IOT_THING_NAME environment variable must be defined")
+	}
+
+	url, ok = os.LookupEnv("AWS_IOT_CREDENTIALS_URL")
+	if !ok {
+		panic("AWS_IOT_CREDENTIALS_URL environment variable must be defined")
+	}
+
+	code := m.Run()
+	os.Exit(code)
+}
+
+var _ awscredentials.Provider = (*Provider)(nil)
+
+func TestNewCredentials(t *testing.T) {
+	s, err := credentials.NewService(url, certPath, privateKeyPath, thingName)
+	assert.NoError(t, err, "credentials service created without error")
+
+	creds := NewCredentials(s, time.Minute)
+	value, err := creds.Get()
+	assert.NoError(t, err, "credentials retrieved without error")
+	assert.NotEmpty(t, value.AccessKeyID, "the retrieved access key ID is not empty")
+	assert.Equal(t, ProviderName, value.ProviderName, "the provider name is reported")
+	assert.False(t, creds.IsExpired(), "the retrieved credentials aren't expired")
+
+	expiresAt, err := creds.ExpiresAt()
+	assert.NoError(t, err, "the credentials expiration is tracked")
+	assert.True(t, expiresAt.After(time.Now()), "the credentials expire in the future")
+}
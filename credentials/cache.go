@@ -0,0 +1,146 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kuzemkon/aws-iot-device-sdk-go/clock"
+)
+
+// ExpiresAt parses the credentials expiration time
+func (o Output) ExpiresAt() (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, o.Expiration)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse the credentials expiration: %w", err)
+	}
+	return t, nil
+}
+
+// TTL returns the time left until the credentials expire. The duration is negative if the credentials have already
+// expired
+func (o Output) TTL() (time.Duration, error) {
+	expiresAt, err := o.ExpiresAt()
+	if err != nil {
+		return 0, err
+	}
+	return time.Until(expiresAt), nil
+}
+
+// CachingProvider caches the AWS credentials retrieved by the services until they are about to expire. The cache is
+// keyed by the credentials URL, the thing name and the additional headers, so the credentials of the different role
+// aliases, things and header sets are cached and refreshed independently. CachingProvider is safe for concurrent use
+type CachingProvider struct {
+	refreshWindow time.Duration
+	clock         clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// cacheEntry the cached credentials of a single credentials request
+type cacheEntry struct {
+	mu        sync.Mutex
+	output    Output
+	expiresAt time.Time
+	// retryAt the time before which the credentials provider mustn't be requested after it throttled the request
+	retryAt time.Time
+}
+
+// NewCachingProvider returns a new instance of the CachingProvider. The credentials are refreshed once they are going
+// to expire within the refresh window
+func NewCachingProvider(refreshWindow time.Duration) *CachingProvider {
+	return NewCachingProviderWithClock(refreshWindow, clock.Real{})
+}
+
+// NewCachingProviderWithClock acts like NewCachingProvider but measures the credentials expiration and the throttling
+// back off with the provided clock
+func NewCachingProviderWithClock(refreshWindow time.Duration, c clock.Clock) *CachingProvider {
+	return &CachingProvider{
+		refreshWindow: refreshWindow,
+		clock:         c,
+		entries:       make(map[string]*cacheEntry),
+	}
+}
+
+// GetCredentials returns the cached credentials for the service credentials request or retrieves the new ones using the
+// service if they are missing or about to expire. After the request is throttled the provider backs off for the
+// requested time: the cached credentials are returned while still valid, otherwise the ThrottledError is returned
+// without performing the request
+func (p *CachingProvider) GetCredentials(s Service) (Output, error) {
+	key := s.cacheKey()
+
+	p.mu.Lock()
+	e, ok := p.entries[key]
+	if !ok {
+		e = &cacheEntry{}
+		p.entries[key] = e
+	}
+	p.mu.Unlock()
+
+	// the entry lock makes the concurrent callers of the same request wait for a single refresh, while the other
+	// requests are refreshed independently
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := p.clock.Now()
+	if e.expiresAt.Sub(now) > p.refreshWindow {
+		return e.output, nil
+	}
+
+	if wait := e.retryAt.Sub(now); wait > 0 {
+		if e.expiresAt.After(now) {
+			return e.output, nil
+		}
+		return Output{}, &ThrottledError{RetryAfter: wait, Message: "backing off after the previous throttled request"}
+	}
+
+	out, err := s.GetCredentials()
+	if err != nil {
+		var throttled *ThrottledError
+		if errors.As(err, &throttled) {
+			now := p.clock.Now()
+			e.retryAt = now.Add(throttled.RetryAfter)
+			if e.expiresAt.After(now) {
+				return e.output, nil
+			}
+		}
+		return Output{}, err
+	}
+
+	expiresAt, err := out.ExpiresAt()
+	if err != nil {
+		return Output{}, err
+	}
+
+	e.output = out
+	e.expiresAt = expiresAt
+	return out, nil
+}
+
+// cacheKey identifies the credentials request of the service, i.e. the credentials URL, the thing name and the sorted
+// additional headers
+func (s Service) cacheKey() string {
+	var b strings.Builder
+	b.WriteString(s.url)
+	b.WriteByte('\n')
+	b.WriteString(s.thingName)
+
+	keys := make([]string, 0, len(s.headers))
+	for key := range s.headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range s.headers[key] {
+			b.WriteByte('\n')
+			b.WriteString(key)
+			b.WriteByte(':')
+			b.WriteString(value)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package credentials
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestOutput_ExpiresAt(t *testing.T) {
+	expiresAt, err := Output{Expiration: "2018-01-18T09:18:06Z"}.ExpiresAt()
+	assert.NoError(t, err, "expiration parsed without error")
+	assert.Equal(t, time.Date(2018, 1, 18, 9, 18, 6, 0, time.UTC), expiresAt)
+
+	_, err = Output{Expiration: "tomorrow"}.ExpiresAt()
+	assert.Error(t, err, "invalid expiration is rejected")
+}
+
+func TestOutput_TTL(t *testing.T) {
+	ttl, err := Output{Expiration: time.Now().Add(time.Hour).Format(time.RFC3339)}.TTL()
+	assert.NoError(t, err, "TTL computed without error")
+	assert.InDelta(t, float64(time.Hour), float64(ttl), float64(2*time.Second), "TTL is close to an hour")
+
+	ttl, err = Output{Expiration: "2018-01-18T09:18:06Z"}.TTL()
+	assert.NoError(t, err, "TTL of expired credentials computed without error")
+	assert.True(t, ttl < 0, "TTL of expired credentials is negative")
+
+	_, err = Output{Expiration: "tomorrow"}.TTL()
+	assert.Error(t, err, "invalid expiration is rejected")
+}
+
+func TestCachingProvider_GetCredentials(t *testing.T) {
+	s, err := NewService(url, certPath, privateKeyPath, thingName)
+	assert.NoError(t, err, "credentials service created without error")
+
+	p := NewCachingProvider(time.Minute)
+
+	out, err := p.GetCredentials(s)
+	assert.NoError(t, err, "credentials retrieved without error")
+
+	cached, err := p.GetCredentials(s)
+	assert.NoError(t, err, "cached credentials retrieved without error")
+	assert.Equal(t, out, cached, "the credentials are served from the cache")
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.now.Add(d)
+	return ch
+}
+
+func TestCachingProvider_Clock(t *testing.T) {
+	s := Service{url: "https://example.com/role-aliases/alias/credentials"}
+	c := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	p := NewCachingProviderWithClock(time.Minute, c)
+
+	cached := Output{AccessKeyId: "cached"}
+	p.entries[s.cacheKey()] = &cacheEntry{
+		output:    cached,
+		expiresAt: c.now.Add(2 * time.Minute),
+		retryAt:   c.now.Add(10 * time.Minute),
+	}
+
+	out, err := p.GetCredentials(s)
+	assert.NoError(t, err, "cached credentials retrieved without error")
+	assert.Equal(t, cached, out, "the credentials outside the refresh window are served from the cache")
+
+	c.now = c.now.Add(90 * time.Second)
+	out, err = p.GetCredentials(s)
+	assert.NoError(t, err, "cached credentials retrieved while backing off without error")
+	assert.Equal(t, cached, out, "the valid credentials are served from the cache while backing off")
+
+	c.now = c.now.Add(time.Minute)
+	_, err = p.GetCredentials(s)
+
+	var throttled *ThrottledError
+	assert.True(t, errors.As(err, &throttled), "the expired credentials aren't requested while backing off")
+	assert.Equal(t, 7*time.Minute+30*time.Second, throttled.RetryAfter, "the back off time is measured with the clock")
+}
+
+func TestService_CacheKey(t *testing.T) {
+	s := Service{url: "https://example.com/role-aliases/alias/credentials", thingName: "thing"}
+	assert.Equal(t, s.cacheKey(), s.cacheKey(), "the key is stable")
+	assert.NotEqual(t, s.cacheKey(), Service{url: s.url, thingName: "other"}.cacheKey(), "the things are cached separately")
+	assert.NotEqual(t, s.cacheKey(), s.WithHeader("x-amzn-correlation-id", "42").cacheKey(), "the header sets are cached separately")
+
+	ab := s.WithHeader("a", "1").WithHeader("b", "2")
+	ba := s.WithHeader("b", "2").WithHeader("a", "1")
+	assert.Equal(t, ab.cacheKey(), ba.cacheKey(), "the headers order doesn't matter")
+}
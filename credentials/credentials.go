@@ -3,9 +3,14 @@ package credentials
 import (
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,7 +21,32 @@ import (
 type Service struct {
 	url       string
 	thingName string
-	tlsCert   tls.Certificate
+	cert      *certificate
+	headers   http.Header
+}
+
+// certificate holds the device certificate shared by all the copies of the Service, so it can be reloaded
+type certificate struct {
+	mu      sync.RWMutex
+	tlsCert tls.Certificate
+}
+
+// ErrNotInitialized is returned by the Service which isn't created by the constructors, e.g. its zero value, and so
+// has no device certificate
+var ErrNotInitialized = errors.New("credentials: service not initialized with a certificate")
+
+// get returns the current device certificate
+func (c *certificate) get() tls.Certificate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tlsCert
+}
+
+// set replaces the device certificate
+func (c *certificate) set(tlsCert tls.Certificate) {
+	c.mu.Lock()
+	c.tlsCert = tlsCert
+	c.mu.Unlock()
 }
 
 // Output the AWS credentials output data structure
@@ -39,20 +69,125 @@ func NewService(iotCredentialsURL, certPath, privateKeyPath, thingName string) (
 		return Service{}, fmt.Errorf("failed to load the certificates: %v", err)
 	}
 
+	return NewServiceWithCertificate(iotCredentialsURL, tlsCert, thingName), nil
+}
+
+// NewServiceWithCertificate acts like NewService but takes the already loaded device certificate, e.g. the one shared
+// with the MQTT connection
+func NewServiceWithCertificate(iotCredentialsURL string, tlsCert tls.Certificate, thingName string) Service {
 	return Service{
 		url:       iotCredentialsURL,
 		thingName: thingName,
-		tlsCert:   tlsCert,
-	}, nil
+		cert:      &certificate{tlsCert: tlsCert},
+	}
+}
+
+// ReloadCertificate loads the device certificates from the provided paths and replaces the ones used by the Service,
+// so the subsequent GetCredentials calls use the new certificates. Intended for the certificate rotation
+func (s Service) ReloadCertificate(certPath, privateKeyPath string) error {
+	if s.cert == nil {
+		return ErrNotInitialized
+	}
+
+	tlsCert, err := tls.LoadX509KeyPair(certPath, privateKeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load the certificates: %v", err)
+	}
+
+	s.cert.set(tlsCert)
+	return nil
+}
+
+// ReloadCertificateFromBytes acts like ReloadCertificate but takes the PEM encoded certificate and private key
+func (s Service) ReloadCertificateFromBytes(certPEM, privateKeyPEM []byte) error {
+	if s.cert == nil {
+		return ErrNotInitialized
+	}
+
+	tlsCert, err := tls.X509KeyPair(certPEM, privateKeyPEM)
+	if err != nil {
+		return fmt.Errorf("failed to parse the certificates: %v", err)
+	}
+
+	s.cert.set(tlsCert)
+	return nil
+}
+
+// WithHeader returns a copy of the Service attaching the additional header to the credentials requests, e.g. a
+// correlation ID or a custom attribute used in the IAM policy conditions. The x-amzn-iot-thingname header is always set
+// to the Service thing name and can't be overridden
+func (s Service) WithHeader(key, value string) Service {
+	headers := s.headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	headers.Add(key, value)
+
+	s.headers = headers
+	return s
+}
+
+// roleAliasPattern matches the role alias names allowed by AWS IoT
+var roleAliasPattern = regexp.MustCompile(`^[\w=,@-]{1,128}$`)
+
+// WithRoleAlias returns a copy of the Service requesting the credentials of the provided role alias, so the device can
+// request the different permission scopes. The role alias segment of the credentials URL is replaced, so the URL must
+// satisfy the pattern described in NewService
+func (s Service) WithRoleAlias(alias string) (Service, error) {
+	if !roleAliasPattern.MatchString(alias) {
+		return Service{}, fmt.Errorf("invalid role alias %q: must be 1-128 characters long and contain only a-z, A-Z, 0-9, '_', '=', ',', '@' and '-'", alias)
+	}
+
+	u, err := neturl.Parse(s.url)
+	if err != nil {
+		return Service{}, fmt.Errorf("failed to parse the credentials URL: %v", err)
+	}
+
+	segments := strings.Split(u.Path, "/")
+	for i := 0; i < len(segments)-1; i++ {
+		if segments[i] == "role-aliases" {
+			segments[i+1] = alias
+			u.Path = strings.Join(segments, "/")
+			s.url = u.String()
+			return s, nil
+		}
+	}
+
+	return Service{}, fmt.Errorf("the credentials URL %s doesn't contain the role alias", s.url)
+}
+
+// GetCredentialsForRole acts like GetCredentials but requests the credentials of the provided role alias
+func (s Service) GetCredentialsForRole(alias string) (Output, error) {
+	withAlias, err := s.WithRoleAlias(alias)
+	if err != nil {
+		return Output{}, err
+	}
+	return withAlias.GetCredentials()
 }
 
 // GetCredentials performs the HTTPS request authorized by the device TLS certificates in order to get the AWS credentials.
-// Returns the Output object with the AWS credentials
+// Returns the Output object with the AWS credentials or the ThrottledError in case the request was throttled. In case the
+// request fails the error contains the truncated response body. In case the successful response fails to parse the
+// Output holds the fields parsed so far and the error contains the truncated response body with the secrets redacted.
+// The proxy configured with the HTTPS_PROXY and NO_PROXY environment variables is used for the request
 func (s Service) GetCredentials() (Output, error) {
+	out, _, err := s.GetCredentialsRaw()
+	return out, err
+}
+
+// GetCredentialsRaw acts like GetCredentials but also returns the raw credentials object of the response, so the fields
+// which aren't parsed into the Output can be read or the response can be stored as is. In case the response body isn't
+// a valid JSON object the whole body is returned instead, so the partial payload isn't lost
+func (s Service) GetCredentialsRaw() (Output, json.RawMessage, error) {
+	if s.cert == nil {
+		return Output{}, nil, ErrNotInitialized
+	}
+
 	client := &http.Client{
 		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
 			TLSClientConfig: &tls.Config{
-				Certificates: []tls.Certificate{s.tlsCert},
+				Certificates: []tls.Certificate{s.cert.get()},
 			},
 		},
 		Timeout: time.Second * 10,
@@ -60,32 +195,78 @@ func (s Service) GetCredentials() (Output, error) {
 
 	req, err := http.NewRequest("GET", s.url, nil)
 	if err != nil {
-		return Output{}, fmt.Errorf("failed to create the credentials request: %v", err)
+		return Output{}, nil, fmt.Errorf("failed to create the credentials request: %v", err)
 	}
 
-	req.Header.Add("x-amzn-iot-thingname", s.thingName)
+	for key, values := range s.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("x-amzn-iot-thingname", s.thingName)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return Output{}, fmt.Errorf("failed to perform the GET credentials request: %v", err)
+		return Output{}, nil, fmt.Errorf("failed to perform the GET credentials request: %v", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return Output{}, fmt.Errorf("failed to parse the response body: %v", err)
+			return Output{}, nil, fmt.Errorf("failed to parse the response body: %v", err)
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return Output{}, nil, &ThrottledError{
+				RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+				Message:    string(body),
+			}
 		}
 
-		return Output{}, fmt.Errorf("the request has failed with the status code: %d; message: %s", resp.StatusCode, string(body))
+		return Output{}, nil, fmt.Errorf("the request has failed with the status code: %d; message: %s", resp.StatusCode, truncate(body, maxErrorBodyLength))
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Output{}, nil, fmt.Errorf("failed to read the credentials response body: %v", err)
 	}
 
 	result := struct {
-		Credentials Output `json:"credentials"`
+		Credentials json.RawMessage `json:"credentials"`
 	}{}
 
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return Output{}, fmt.Errorf("failed to parse credentials response body: %v", err)
+	out := Output{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return out, body, fmt.Errorf("failed to parse credentials response body: %v; body: %s", err, redactedBody(body))
+	}
+	if len(result.Credentials) == 0 {
+		return out, nil, nil
 	}
+	if err := json.Unmarshal(result.Credentials, &out); err != nil {
+		return out, result.Credentials, fmt.Errorf("failed to parse credentials response body: %v; body: %s", err, redactedBody(body))
+	}
+
+	return out, result.Credentials, nil
+}
+
+// maxErrorBodyLength the maximum length of the response body included into the errors
+const maxErrorBodyLength = 256
 
-	return result.Credentials, nil
+// secretFieldPattern matches the secret fields of the credentials response along with their values, including the value
+// cut by the end of the body
+var secretFieldPattern = regexp.MustCompile(`"(accessKeyId|secretAccessKey|sessionToken)"\s*:\s*"(?:[^"\\]|\\.)*\\?("|$)`)
+
+// redactedBody returns the credentials response body with the secret field values replaced, cut to the maximum length
+// of the body included into the errors
+func redactedBody(body []byte) string {
+	redacted := secretFieldPattern.ReplaceAll(body, []byte(`"$1":"REDACTED"`))
+	return truncate(redacted, maxErrorBodyLength)
+}
+
+// truncate returns the body cut to the maximum length, the cut body is suffixed with the ellipsis
+func truncate(body []byte, max int) string {
+	if len(body) <= max {
+		return string(body)
+	}
+	return string(body[:max]) + "..."
 }
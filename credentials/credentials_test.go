@@ -43,3 +43,84 @@ func TestService_GetCredentials(t *testing.T) {
 
 	fmt.Println(out)
 }
+
+func TestService_ReloadCertificate(t *testing.T) {
+	s, err := NewService(url, certPath, privateKeyPath, thingName)
+	assert.NoError(t, err, "credentials service created without error")
+
+	err = s.ReloadCertificate(certPath, privateKeyPath)
+	assert.NoError(t, err, "certificate reloaded without error")
+
+	out, err := s.GetCredentials()
+	assert.NoError(t, err, "credentials retrieved with reloaded certificate without error")
+	assert.NotEmpty(t, out.AccessKeyId, "the retrieved accessKeyId is not empty")
+
+	err = s.ReloadCertificateFromBytes([]byte("invalid"), []byte("invalid"))
+	assert.Error(t, err, "invalid certificate is rejected")
+}
+
+func TestService_WithHeader(t *testing.T) {
+	s, err := NewService(url, certPath, privateKeyPath, thingName)
+	assert.NoError(t, err, "credentials service created without error")
+
+	withHeader := s.WithHeader("x-amzn-correlation-id", "42")
+	assert.Empty(t, s.headers, "the original service headers are left untouched")
+	assert.Equal(t, "42", withHeader.headers.Get("x-amzn-correlation-id"), "the header is attached to the copy")
+
+	out, err := withHeader.GetCredentials()
+	assert.NoError(t, err, "credentials retrieved with the additional header without error")
+	assert.NotEmpty(t, out.AccessKeyId, "the retrieved accessKeyId is not empty")
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate([]byte("short"), 10), "short body is kept")
+	assert.Equal(t, "long b...", truncate([]byte("long body"), 6), "long body is truncated")
+}
+
+func TestService_NotInitialized(t *testing.T) {
+	var s Service
+
+	_, err := s.GetCredentials()
+	assert.Equal(t, ErrNotInitialized, err, "zero service fails without the request")
+
+	err = s.ReloadCertificateFromBytes([]byte("invalid"), []byte("invalid"))
+	assert.Equal(t, ErrNotInitialized, err, "zero service can't reload the certificate")
+}
+
+func TestRedactedBody(t *testing.T) {
+	body := []byte(`{"credentials":{"accessKeyId":"AKID","secretAccessKey":"se\"cret","sessionToken":"token","expiration":1}}`)
+	redacted := redactedBody(body)
+	assert.Equal(t, `{"credentials":{"accessKeyId":"REDACTED","secretAccessKey":"REDACTED","sessionToken":"REDACTED","expiration":1}}`, redacted, "the secrets are redacted")
+
+	redacted = redactedBody([]byte(`{"credentials":{"accessKeyId":"AKID","sessionToken":"tok`))
+	assert.NotContains(t, redacted, "tok", "the secret cut by the end of the body is redacted")
+	assert.NotContains(t, redacted, "AKID", "the complete secret is redacted")
+
+	redacted = redactedBody([]byte(`{"credentials":{"sessionToken":"tok\`))
+	assert.NotContains(t, redacted, "tok", "the secret cut after the escape is redacted")
+}
+
+func TestService_WithRoleAlias(t *testing.T) {
+	s := Service{url: "https://example.credentials.iot.us-east-1.amazonaws.com/role-aliases/reader/credentials"}
+
+	withAlias, err := s.WithRoleAlias("writer")
+	assert.NoError(t, err, "role alias replaced without error")
+	assert.Equal(t, "https://example.credentials.iot.us-east-1.amazonaws.com/role-aliases/writer/credentials", withAlias.url)
+	assert.Equal(t, "https://example.credentials.iot.us-east-1.amazonaws.com/role-aliases/reader/credentials", s.url, "the original service is left untouched")
+
+	_, err = s.WithRoleAlias("writer/../admin")
+	assert.Error(t, err, "role alias with slash is rejected")
+
+	_, err = Service{url: "https://example.com/credentials"}.WithRoleAlias("writer")
+	assert.Error(t, err, "URL without role alias is rejected")
+}
+
+func TestService_GetCredentialsRaw(t *testing.T) {
+	s, err := NewService(url, certPath, privateKeyPath, thingName)
+	assert.NoError(t, err, "credentials service created without error")
+
+	out, raw, err := s.GetCredentialsRaw()
+	assert.NoError(t, err, "raw credentials retrieved without error")
+	assert.NotEmpty(t, out.AccessKeyId, "the retrieved accessKeyId is not empty")
+	assert.Contains(t, string(raw), out.AccessKeyId, "the raw credentials object is returned")
+}
@@ -0,0 +1,40 @@
+package credentials
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRetryAfter the backoff used when the throttled response doesn't specify the Retry-After header
+const DefaultRetryAfter = 10 * time.Second
+
+// ThrottledError is returned by GetCredentials when the credentials provider throttles the requests with the HTTP 429
+// status. RetryAfter specifies how long to wait before the next request
+type ThrottledError struct {
+	RetryAfter time.Duration
+	Message    string
+}
+
+// Error returns the throttling message and the retry delay
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("the credentials request has been throttled, retry after %s; message: %s", e.RetryAfter, e.Message)
+}
+
+// parseRetryAfter parses the Retry-After header value, which is either the number of seconds or the HTTP date.
+// Returns DefaultRetryAfter if the value is absent or invalid
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d
+		}
+		return 0
+	}
+
+	return DefaultRetryAfter
+}
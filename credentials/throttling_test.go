@@ -0,0 +1,18 @@
+package credentials
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 120*time.Second, parseRetryAfter("120", now), "seconds are parsed")
+	assert.Equal(t, 30*time.Second, parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now), "HTTP date is parsed")
+	assert.Equal(t, time.Duration(0), parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now), "past HTTP date means no delay")
+	assert.Equal(t, DefaultRetryAfter, parseRetryAfter("", now), "absent header falls back to the default")
+	assert.Equal(t, DefaultRetryAfter, parseRetryAfter("soon", now), "invalid header falls back to the default")
+}
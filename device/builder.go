@@ -0,0 +1,69 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ShadowBuilder assembles the shadow update document field by field, e.g.
+//
+//	s, err := NewShadowBuilder().Reported("temperature", 21).DeleteReported("humidity").Build()
+//
+// The fields set again replace the previous values
+type ShadowBuilder struct {
+	desired  map[string]interface{}
+	reported map[string]interface{}
+}
+
+// NewShadowBuilder returns a new instance of the ShadowBuilder without any fields
+func NewShadowBuilder() *ShadowBuilder {
+	return &ShadowBuilder{
+		desired:  make(map[string]interface{}),
+		reported: make(map[string]interface{}),
+	}
+}
+
+// Desired sets the field of the desired state. The value is marshaled to JSON by Build
+func (b *ShadowBuilder) Desired(key string, value interface{}) *ShadowBuilder {
+	b.desired[key] = value
+	return b
+}
+
+// Reported sets the field of the reported state. The value is marshaled to JSON by Build
+func (b *ShadowBuilder) Reported(key string, value interface{}) *ShadowBuilder {
+	b.reported[key] = value
+	return b
+}
+
+// DeleteDesired sets the field of the desired state to null, so AWS IoT deletes it from the shadow
+func (b *ShadowBuilder) DeleteDesired(key string) *ShadowBuilder {
+	return b.Desired(key, nil)
+}
+
+// DeleteReported sets the field of the reported state to null, so AWS IoT deletes it from the shadow
+func (b *ShadowBuilder) DeleteReported(key string) *ShadowBuilder {
+	return b.Reported(key, nil)
+}
+
+// Build returns the shadow update document containing the desired and reported fields set so far. Returns an error if
+// no field is set or any of the values fails to marshal
+func (b *ShadowBuilder) Build() (Shadow, error) {
+	if len(b.desired) == 0 && len(b.reported) == 0 {
+		return nil, errors.New("shadow update must contain at least one field")
+	}
+
+	state := make(map[string]map[string]interface{})
+	if len(b.desired) > 0 {
+		state["desired"] = b.desired
+	}
+	if len(b.reported) > 0 {
+		state["reported"] = b.reported
+	}
+
+	s, err := json.Marshal(map[string]interface{}{"state": state})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the shadow update: %w", err)
+	}
+	return s, nil
+}
@@ -0,0 +1,28 @@
+package device
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestShadowBuilder(t *testing.T) {
+	s, err := NewShadowBuilder().
+		Reported("temperature", 21).
+		Reported("sensors", map[string]int{"humidity": 40}).
+		DeleteReported("legacy").
+		Desired("mode", "eco").
+		Build()
+	assert.NoError(t, err, "shadow update built without error")
+	assert.JSONEq(t, `{"state":{"desired":{"mode":"eco"},"reported":{"temperature":21,"sensors":{"humidity":40},"legacy":null}}}`, s.String(), "the update contains all the fields")
+	assert.NoError(t, ValidateShadow(s), "the built update is valid")
+
+	s, err = NewShadowBuilder().Desired("mode", "eco").Desired("mode", "boost").Build()
+	assert.NoError(t, err, "shadow update built without error")
+	assert.JSONEq(t, `{"state":{"desired":{"mode":"boost"}}}`, s.String(), "the field set again replaces the previous value")
+
+	_, err = NewShadowBuilder().Build()
+	assert.Error(t, err, "empty update is rejected")
+
+	_, err = NewShadowBuilder().Reported("invalid", make(chan int)).Build()
+	assert.Error(t, err, "unmarshalable value is rejected")
+}
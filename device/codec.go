@@ -0,0 +1,92 @@
+package device
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// Codec transforms the custom topic payloads, e.g. compresses them. Encode is applied before publishing and Decode is
+// applied to the received messages, so both sides of the custom topic must use the same codec
+type Codec interface {
+	Encode(payload []byte) ([]byte, error)
+	Decode(payload []byte) ([]byte, error)
+}
+
+// GzipCodec compresses the custom topic payloads with gzip
+type GzipCodec struct {
+	// Level is the gzip compression level, zero means gzip.DefaultCompression
+	Level int
+}
+
+// Encode compresses the payload
+func (c GzipCodec) Encode(payload []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decompresses the payload
+func (c GzipCodec) Decode(payload []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// WithPayloadCodec configures the Codec applied to the payloads published to and received from the custom topics. The
+// shadow topics aren't affected, since AWS IoT requires the plain JSON there
+func WithPayloadCodec(c Codec) Option {
+	return func(o *options) error {
+		if c == nil {
+			return errors.New("payload codec must not be nil")
+		}
+
+		o.codec = c
+		return nil
+	}
+}
+
+// encodePayload applies the configured codec to the outgoing custom topic payload
+func (t *Thing) encodePayload(payload []byte) ([]byte, error) {
+	if t.opts.codec == nil {
+		return payload, nil
+	}
+
+	encoded, err := t.opts.codec.Encode(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the payload: %w", err)
+	}
+	return encoded, nil
+}
+
+// decodePayload applies the configured codec to the incoming custom topic payload
+func (t *Thing) decodePayload(payload []byte) ([]byte, error) {
+	if t.opts.codec == nil {
+		return payload, nil
+	}
+
+	decoded, err := t.opts.codec.Decode(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the payload: %w", err)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,21 @@
+package device
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGzipCodec(t *testing.T) {
+	c := GzipCodec{}
+
+	encoded, err := c.Encode([]byte(`{"value":1}`))
+	assert.NoError(t, err, "payload encoded without error")
+	assert.NotEqual(t, []byte(`{"value":1}`), encoded, "the encoded payload is compressed")
+
+	decoded, err := c.Decode(encoded)
+	assert.NoError(t, err, "payload decoded without error")
+	assert.Equal(t, []byte(`{"value":1}`), decoded, "the decoded payload matches the original one")
+
+	_, err = c.Decode([]byte("plain"))
+	assert.Error(t, err, "uncompressed payload is rejected")
+}
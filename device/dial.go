@@ -0,0 +1,50 @@
+package device
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+)
+
+// newTLSConnectionFn returns the MQTT connection function opening the TLS connection to the broker directly. Unlike
+// the MQTT client default one, the TLS handshake is limited by its own timeout rather than sharing the connect one
+func newTLSConnectionFn(handshakeTimeout time.Duration) mqtt.OpenConnectionFunc {
+	return func(uri *url.URL, opts mqtt.ClientOptions) (net.Conn, error) {
+		dialer := &net.Dialer{Timeout: opts.ConnectTimeout}
+		conn, err := dialer.Dial("tcp", uri.Host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", uri.Host, err)
+		}
+
+		return tlsHandshake(conn, uri, opts.TLSConfig, handshakeTimeout)
+	}
+}
+
+// tlsHandshake performs the TLS handshake with the broker over the opened connection within the timeout, zero means no
+// timeout. The connection is closed if the handshake fails
+func tlsHandshake(conn net.Conn, uri *url.URL, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, error) {
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = uri.Hostname()
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to perform the TLS handshake with %s: %w", uri.Host, err)
+	}
+
+	return tlsConn, nil
+}
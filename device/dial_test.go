@@ -0,0 +1,52 @@
+package device
+
+import (
+	"bytes"
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestNewTLSConnectionFn_HandshakeTimeout(t *testing.T) {
+	// the listener accepts the TCP connections but never answers the TLS handshake, like a black-holed network
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "listener created without error")
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	uri, _ := url.Parse("ssl://" + l.Addr().String())
+	opts := mqtt.NewClientOptions()
+	opts.SetTLSConfig(&tls.Config{})
+
+	start := time.Now()
+	_, err = newTLSConnectionFn(100*time.Millisecond)(uri, *opts)
+	assert.Error(t, err, "the hanging handshake fails")
+	assert.True(t, time.Since(start) < 5*time.Second, "the handshake fails after the timeout")
+
+	assert.Error(t, WithTLSHandshakeTimeout(0)(defaultOptions()), "zero TLS handshake timeout is rejected")
+}
+
+func TestWithKeyLogWriter(t *testing.T) {
+	var keyLog bytes.Buffer
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{}, WithKeyLogWriter(&keyLog))
+	assert.NoError(t, err, "thing instance with key log writer created without error")
+
+	opts := thing.newClientOptions(&tls.Config{})
+	assert.Equal(t, &keyLog, opts.TLSConfig.KeyLogWriter, "the key log writer is set to the TLS config")
+
+	assert.Error(t, WithKeyLogWriter(nil)(defaultOptions()), "nil key log writer is rejected")
+}
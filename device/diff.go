@@ -0,0 +1,69 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// DiffShadows compares the state sections of the two shadow documents and returns the fields which differ in the new
+// one. The nested objects are compared recursively, so only the changed nested fields are returned. The fields
+// missing in the new shadow are returned with the nil value, just like the shadow update deleting them
+func DiffShadows(old, new Shadow) (map[string]interface{}, error) {
+	oldState, err := decodeShadowState(old)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the old shadow: %w", err)
+	}
+
+	newState, err := decodeShadowState(new)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the new shadow: %w", err)
+	}
+
+	return diffObjects(oldState, newState), nil
+}
+
+// decodeShadowState decodes the state section of the shadow document
+func decodeShadowState(s Shadow) (map[string]interface{}, error) {
+	doc := struct {
+		State map[string]interface{} `json:"state"`
+	}{}
+	if err := json.Unmarshal(s, &doc); err != nil {
+		return nil, err
+	}
+	return doc.State, nil
+}
+
+// diffObjects returns the fields of the new object which are added or changed comparing to the old one and the nil
+// values for the removed fields
+func diffObjects(old, new map[string]interface{}) map[string]interface{} {
+	diff := make(map[string]interface{})
+	for k, n := range new {
+		o, ok := old[k]
+		if !ok {
+			diff[k] = n
+			continue
+		}
+
+		oObj, oIsObj := o.(map[string]interface{})
+		nObj, nIsObj := n.(map[string]interface{})
+		if oIsObj && nIsObj {
+			if nested := diffObjects(oObj, nObj); len(nested) > 0 {
+				diff[k] = nested
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(o, n) {
+			diff[k] = n
+		}
+	}
+
+	for k := range old {
+		if _, ok := new[k]; !ok {
+			diff[k] = nil
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,28 @@
+package device
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDiffShadows(t *testing.T) {
+	old := Shadow(`{"state":{"reported":{"color":"red","power":{"on":true,"level":1},"error":"overheat"},"desired":{"color":"red"}}}`)
+	new := Shadow(`{"state":{"reported":{"color":"red","power":{"on":true,"level":2},"mode":"auto"},"desired":{"color":"red"}}}`)
+
+	diff, err := DiffShadows(old, new)
+	assert.NoError(t, err, "shadows compared without error")
+	assert.Equal(t, map[string]interface{}{
+		"reported": map[string]interface{}{
+			"power": map[string]interface{}{"level": float64(2)},
+			"mode":  "auto",
+			"error": nil,
+		},
+	}, diff, "only the changed nested fields and the deletions are in the diff")
+
+	diff, err = DiffShadows(old, old)
+	assert.NoError(t, err, "equal shadows compared without error")
+	assert.Empty(t, diff, "equal shadows have no diff")
+
+	_, err = DiffShadows(old, Shadow("invalid JSON"))
+	assert.Error(t, err, "invalid shadow is rejected")
+}
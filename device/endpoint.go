@@ -0,0 +1,139 @@
+package device
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// regionPattern matches the AWS region names, e.g. us-east-1 or ap-southeast-2
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// ATSEndpoint builds the AWS IoT ATS data endpoint based on the account specific prefix and the AWS region.
+// The result satisfies this pattern: <prefix>-ats.iot.<region>.amazonaws.com
+func ATSEndpoint(accountPrefix, region string) string {
+	return fmt.Sprintf("%s-ats.iot.%s.amazonaws.com", accountPrefix, region)
+}
+
+// hostPattern matches the host names and the IPv4 addresses
+var hostPattern = regexp.MustCompile(`^[a-z0-9.-]+$`)
+
+// RegionFromEndpoint extracts the AWS region from the AWS IoT data endpoint, e.g. "us-east-1" from
+// "xxxxxxxxxx-ats.iot.us-east-1.amazonaws.com"
+func RegionFromEndpoint(endpoint string) (string, error) {
+	region, found, err := endpointRegion(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("failed to find the region in the endpoint %s", endpoint)
+	}
+	return region, nil
+}
+
+// endpointRegion finds the AWS region in the AWS IoT endpoint host, i.e. the label following the "iot" or "iot-fips"
+// one and followed by the "amazonaws" or the VPC "vpce.amazonaws" ones. found is false if the host has no such labels,
+// while the error is returned if it has them but the region isn't valid
+func endpointRegion(host string) (region string, found bool, err error) {
+	labels := strings.Split(host, ".")
+	for i := 0; i+2 < len(labels); i++ {
+		if labels[i] != "iot" && labels[i] != "iot-fips" {
+			continue
+		}
+		next := labels[i+2]
+		if next == "vpce" && i+3 < len(labels) {
+			next = labels[i+3]
+		}
+		if next != "amazonaws" {
+			continue
+		}
+
+		region = labels[i+1]
+		if !regionPattern.MatchString(region) {
+			return "", true, fmt.Errorf("invalid region %q in the endpoint %s", region, host)
+		}
+		return region, true, nil
+	}
+
+	return "", false, nil
+}
+
+// ParseEndpoint normalizes the AWS IoT data endpoint pasted in any of the common forms, e.g. with the scheme, port or
+// path, and returns its lowercase host along with the AWS region. The ATS, legacy, FIPS and VPC endpoints are accepted
+// and returned as they are, e.g. the legacy endpoint isn't converted to the ATS one as it's served with another
+// certificate. Returns an error if the endpoint doesn't look like the AWS IoT data endpoint
+func ParseEndpoint(raw string) (host string, region string, err error) {
+	host, _, err = splitEndpoint(raw)
+	if err != nil {
+		return "", "", err
+	}
+	host = strings.ToLower(host)
+
+	if !isAWSEndpoint(host) || strings.HasPrefix(host, "iot.") || strings.Contains(host, ".credentials.") {
+		return "", "", fmt.Errorf("invalid endpoint %q: must be the AWS IoT data endpoint <prefix>-ats.iot.<region>.amazonaws.com", raw)
+	}
+
+	region, found, err := endpointRegion(host)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid endpoint %q: %w", raw, err)
+	}
+	if !found {
+		return "", "", fmt.Errorf("invalid endpoint %q: must be the AWS IoT data endpoint <prefix>-ats.iot.<region>.amazonaws.com", raw)
+	}
+
+	return host, region, nil
+}
+
+// isAWSEndpoint reports whether the endpoint belongs to the AWS domain rather than the custom one
+func isAWSEndpoint(host string) bool {
+	return strings.HasSuffix(host, ".amazonaws.com") || strings.HasSuffix(host, ".amazonaws.com.cn")
+}
+
+// splitEndpoint strips the scheme and path of the endpoint and returns its host and port, which is empty unless set
+// explicitly. The host is returned as is, only the obviously wrong ones are rejected
+func splitEndpoint(raw string) (host string, port string, err error) {
+	endpoint := strings.TrimSpace(raw)
+	if strings.Contains(endpoint, "://") {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid endpoint %q: %w", raw, err)
+		}
+		endpoint = u.Host
+	}
+	if i := strings.IndexByte(endpoint, '/'); i >= 0 {
+		endpoint = endpoint[:i]
+	}
+
+	host = endpoint
+	if h, p, err := net.SplitHostPort(endpoint); err == nil {
+		if n, err := strconv.Atoi(p); err != nil || n <= 0 || n > 65535 {
+			return "", "", fmt.Errorf("invalid endpoint %q: invalid port %q", raw, p)
+		}
+		host, port = h, p
+	}
+
+	if check := strings.TrimSuffix(strings.ToLower(host), "."); !hostPattern.MatchString(check) {
+		return "", "", fmt.Errorf("invalid endpoint %q: must be the host name", raw)
+	}
+	return host, port, nil
+}
+
+// resolveEndpoint validates the endpoint the thing connects to and returns its host, the explicit port if any and the
+// region. The host isn't rewritten, so the endpoints of any form AWS IoT serves are passed through, only the AWS
+// endpoint with the invalid region is rejected early. The region is left empty for the custom domain endpoints and
+// the AWS ones of an unknown form
+func resolveEndpoint(raw string) (host string, port string, region string, err error) {
+	host, port, err = splitEndpoint(raw)
+	if err != nil {
+		return "", "", "", err
+	}
+	if lower := strings.ToLower(host); isAWSEndpoint(lower) {
+		if region, _, err = endpointRegion(lower); err != nil {
+			return "", "", "", fmt.Errorf("invalid endpoint %q: %w", raw, err)
+		}
+	}
+	return host, port, region, nil
+}
@@ -0,0 +1,105 @@
+package device
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestATSEndpoint(t *testing.T) {
+	assert.Equal(t, "abc123-ats.iot.us-east-1.amazonaws.com", ATSEndpoint("abc123", "us-east-1"))
+}
+
+func TestRegionFromEndpoint(t *testing.T) {
+	region, err := RegionFromEndpoint("abc123-ats.iot.eu-central-1.amazonaws.com")
+	assert.NoError(t, err, "region parsed without error")
+	assert.Equal(t, "eu-central-1", region)
+
+	region, err = RegionFromEndpoint("abc123-ats.iot.cn-north-1.amazonaws.com.cn")
+	assert.NoError(t, err, "china region parsed without error")
+	assert.Equal(t, "cn-north-1", region)
+
+	_, err = RegionFromEndpoint("abc123-ats.iot.nowhere.amazonaws.com")
+	assert.Error(t, err, "invalid region is rejected")
+
+	region, err = RegionFromEndpoint("data.iot-fips.us-east-1.amazonaws.com")
+	assert.NoError(t, err, "FIPS region parsed without error")
+	assert.Equal(t, "us-east-1", region)
+
+	_, err = RegionFromEndpoint("localhost")
+	assert.Error(t, err, "endpoint without region is rejected")
+}
+
+func TestParseEndpoint(t *testing.T) {
+	for _, raw := range []string{
+		"abc123-ats.iot.us-east-1.amazonaws.com",
+		"ABC123-ATS.iot.us-east-1.amazonaws.com",
+		"ssl://abc123-ats.iot.us-east-1.amazonaws.com:8883",
+		"https://abc123-ats.iot.us-east-1.amazonaws.com/things/thing/shadow",
+		"abc123-ats.iot.us-east-1.amazonaws.com:443",
+		" abc123-ats.iot.us-east-1.amazonaws.com ",
+	} {
+		host, region, err := ParseEndpoint(raw)
+		assert.NoError(t, err, "endpoint %q parsed without error", raw)
+		assert.Equal(t, "abc123-ats.iot.us-east-1.amazonaws.com", host, "endpoint %q is normalized", raw)
+		assert.Equal(t, "us-east-1", region, "region of endpoint %q is extracted", raw)
+	}
+
+	for raw, expected := range map[string]string{
+		"abc123.iot.us-east-1.amazonaws.com":                           "us-east-1",
+		"abc123.ats.iot.cn-north-1.amazonaws.com.cn":                   "cn-north-1",
+		"data.iot-fips.us-east-1.amazonaws.com":                        "us-east-1",
+		"abc123-ats.iot-fips.us-gov-west-1.amazonaws.com":              "us-gov-west-1",
+		"vpce-0123abcd-4567efgh.data.iot.us-east-1.vpce.amazonaws.com": "us-east-1",
+	} {
+		host, region, err := ParseEndpoint(raw)
+		assert.NoError(t, err, "endpoint %q parsed without error", raw)
+		assert.Equal(t, raw, host, "endpoint %q is kept as is", raw)
+		assert.Equal(t, expected, region, "region of endpoint %q is extracted", raw)
+	}
+
+	for _, raw := range []string{
+		"",
+		"localhost",
+		"iot.us-east-1.amazonaws.com",
+		"abc123-ats.iot.nowhere.amazonaws.com",
+		"abc123.credentials.iot.us-east-1.amazonaws.com",
+		"abc_123-ats.iot.us-east-1.amazonaws.com",
+		"abc123-ats.iot.us-east-1.amazonaws.com.evil.com",
+	} {
+		_, _, err := ParseEndpoint(raw)
+		assert.Error(t, err, "invalid endpoint %q is rejected", raw)
+	}
+}
+
+func TestResolveEndpoint(t *testing.T) {
+	endpoint, port, region, err := resolveEndpoint("ssl://iot.example.com:8884")
+	assert.NoError(t, err, "custom domain endpoint resolved without error")
+	assert.Equal(t, "iot.example.com", endpoint, "custom domain endpoint is stripped of the scheme")
+	assert.Equal(t, "8884", port, "the explicit port is kept")
+	assert.Empty(t, region, "custom domain endpoint has no region")
+
+	endpoint, port, region, err = resolveEndpoint("abc123.iot.us-east-1.amazonaws.com")
+	assert.NoError(t, err, "legacy endpoint resolved without error")
+	assert.Equal(t, "abc123.iot.us-east-1.amazonaws.com", endpoint, "legacy endpoint isn't rewritten")
+	assert.Empty(t, port, "no port is set by default")
+	assert.Equal(t, "us-east-1", region, "region of legacy endpoint is extracted")
+
+	endpoint, _, region, err = resolveEndpoint("vpce-0123abcd.data.iot.eu-west-1.vpce.amazonaws.com")
+	assert.NoError(t, err, "VPC endpoint resolved without error")
+	assert.Equal(t, "vpce-0123abcd.data.iot.eu-west-1.vpce.amazonaws.com", endpoint, "VPC endpoint is kept")
+	assert.Equal(t, "eu-west-1", region, "region of VPC endpoint is extracted")
+
+	endpoint, _, region, err = resolveEndpoint("unknown-form.example.amazonaws.com")
+	assert.NoError(t, err, "AWS endpoint of an unknown form is passed through")
+	assert.Equal(t, "unknown-form.example.amazonaws.com", endpoint, "AWS endpoint of an unknown form is kept")
+	assert.Empty(t, region, "AWS endpoint of an unknown form has no region")
+
+	_, _, _, err = resolveEndpoint("abc123-ats.iot.us-esat-1x.amazonaws.com")
+	assert.Error(t, err, "AWS endpoint with a region typo is rejected")
+
+	_, _, _, err = resolveEndpoint("abc123-ats.iot.us-east-1.amazonaws.com:99999")
+	assert.Error(t, err, "invalid port is rejected")
+
+	_, _, _, err = resolveEndpoint("user@abc123-ats.iot.us-east-1.amazonaws.com")
+	assert.Error(t, err, "garbage is rejected")
+}
@@ -0,0 +1,76 @@
+package device
+
+import "sync"
+
+// connectionEventsBufferSize the capacity of the connection events channel
+const connectionEventsBufferSize = 16
+
+// ConnectionState the state of the MQTT connection reported by the ConnectionEvent
+type ConnectionState int
+
+const (
+	// Connected the connection has been established, either initially or after the reconnection, and the subscriptions
+	// have been restored
+	Connected ConnectionState = iota
+	// Disconnected the connection has been lost or terminated with Disconnect
+	Disconnected
+)
+
+// String returns the name of the connection state
+func (s ConnectionState) String() string {
+	switch s {
+	case Connected:
+		return "connected"
+	case Disconnected:
+		return "disconnected"
+	default:
+		return "unknown"
+	}
+}
+
+// ConnectionEvent the change of the MQTT connection state
+type ConnectionEvent struct {
+	State ConnectionState
+	// Err is the cause of the connection loss, it's nil for the connections and the requested disconnections
+	Err error
+}
+
+// ConnectionEvents returns the channel with the connection state changes of the thing. The channel is buffered and the
+// events are delivered on a best-effort basis: in case the channel is full the new events are dropped, so the MQTT
+// client is never blocked by a slow reader
+func (t *Thing) ConnectionEvents() <-chan ConnectionEvent {
+	return t.events
+}
+
+// emitConnectionEvent sends the connection event without blocking
+func (t *Thing) emitConnectionEvent(state ConnectionState, err error) {
+	select {
+	case t.events <- ConnectionEvent{State: state, Err: err}:
+	default:
+	}
+}
+
+// connectionStatus the cause of the most recent connection loss
+type connectionStatus struct {
+	mu      sync.Mutex
+	lastErr error
+}
+
+// ConnectionStatus reports whether the thing is connected along with the cause of the most recent connection loss, e.g.
+// for the health endpoints. The last error is kept after the reconnection and is nil if the connection has never been
+// lost. Disconnect doesn't change the last error as the requested disconnection has no cause
+func (t *Thing) ConnectionStatus() (connected bool, lastError error) {
+	t.status.mu.Lock()
+	lastError = t.status.lastErr
+	t.status.mu.Unlock()
+
+	return t.currentClient().IsConnectionOpen(), lastError
+}
+
+// recordConnectionLoss stores the cause of the connection loss reported by ConnectionStatus
+func (t *Thing) recordConnectionLoss(err error) {
+	t.status.mu.Lock()
+	defer t.status.mu.Unlock()
+
+	t.status.lastErr = err
+}
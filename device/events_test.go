@@ -0,0 +1,117 @@
+package device
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestThing_ConnectionStatus(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{})
+	assert.NoError(t, err, "thing instance created without error")
+
+	connected, lastErr := thing.ConnectionStatus()
+	assert.False(t, connected, "the thing isn't connected before Connect")
+	assert.NoError(t, lastErr, "there's no last error before the connection loss")
+
+	thing.recordConnectionLoss(errors.New("connection reset"))
+	connected, lastErr = thing.ConnectionStatus()
+	assert.False(t, connected, "the thing isn't connected after the connection loss")
+	assert.EqualError(t, lastErr, "connection reset", "the cause of the connection loss is reported")
+}
+
+func TestThing_ReconnectBackoff(t *testing.T) {
+	var attempts []int
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{}, WithReconnectBackoff(func(attempt int) time.Duration {
+		attempts = append(attempts, attempt)
+		return 0
+	}))
+	assert.NoError(t, err, "thing instance with reconnect backoff created without error")
+
+	opts := thing.newClientOptions(&tls.Config{})
+	opts.OnReconnecting(nil, opts)
+	opts.OnReconnecting(nil, opts)
+	assert.Equal(t, []int{1, 2}, attempts, "the backoff is consulted before every attempt")
+	assert.Equal(t, time.Millisecond, opts.MaxReconnectInterval, "the MQTT client backoff is reduced to the minimum")
+
+	assert.Error(t, WithReconnectBackoff(nil)(defaultOptions()), "nil backoff is rejected")
+}
+
+func TestThing_PublishNotConnected(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{}, WithOfflineQueueing(false))
+	assert.NoError(t, err, "thing instance without offline queueing created without error")
+
+	err = thing.PublishToCustomTopic(Shadow(`{"value":1}`), "telemetry")
+	assert.True(t, errors.Is(err, ErrNotConnected), "custom topic publish fails while disconnected")
+
+	err = thing.UpdateThingShadow(Shadow(`{"state":{"reported":{"value":1}}}`))
+	assert.True(t, errors.Is(err, ErrNotConnected), "shadow update fails while disconnected")
+
+	_, err = thing.PublishAsync("topic", []byte("payload"), 1)
+	assert.True(t, errors.Is(err, ErrNotConnected), "async publish fails while disconnected")
+
+	queueing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{})
+	assert.NoError(t, err, "thing instance created without error")
+
+	_, err = queueing.PublishAsync("topic", []byte("payload"), 1)
+	assert.False(t, errors.Is(err, ErrNotConnected), "the publish is passed to the MQTT client by default")
+}
+
+func TestThing_ConnectionLost_RestoresSubscriptions(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{})
+	assert.NoError(t, err, "thing instance created without error")
+
+	_, _, err = thing.SubscribeForCustomTopic("commands")
+	assert.NoError(t, err, "subscription queued without error")
+
+	// the subscription is sent once connected
+	topic := thing.thingTopic("commands")
+	thing.subscriptions[topic].pending = false
+
+	opts := thing.newClientOptions(&tls.Config{})
+	opts.OnConnectionLost(nil, errors.New("connection reset"))
+	assert.True(t, thing.subscriptions[topic].pending, "the lost subscription is restored on the next connection")
+	assert.Len(t, thing.subscriptions[topic].handlers, 1, "the subscription keeps delivering to the same channel")
+}
+
+func TestNewThingFromOptions(t *testing.T) {
+	_, err := NewThingFromOptions(mqtt.NewClientOptions(), "thing")
+	assert.Error(t, err, "options without a broker are rejected")
+
+	_, err = NewThingFromOptions(nil, "thing")
+	assert.Error(t, err, "nil options are rejected")
+
+	opts := mqtt.NewClientOptions().AddBroker("tcp://127.0.0.1:1").SetConnectTimeout(time.Second)
+	_, err = NewThingFromOptions(opts, "thing")
+	assert.Error(t, err, "unreachable broker fails the connection")
+	assert.Nil(t, opts.OnConnect, "the provided options aren't modified")
+}
+
+func TestThing_SetConnectionHandlers(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{})
+	assert.NoError(t, err, "thing instance created without error")
+
+	var lost error
+	opts := mqtt.NewClientOptions().SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		lost = err
+	})
+	thing.setConnectionHandlers(opts)
+
+	opts.OnConnectionLost(nil, errors.New("connection reset"))
+	_, lastErr := thing.ConnectionStatus()
+	assert.EqualError(t, lastErr, "connection reset", "the thing handler is called")
+	assert.EqualError(t, lost, "connection reset", "the handler of the provided options is called as well")
+}
+
+func TestWithResubscribeJitter(t *testing.T) {
+	o := defaultOptions()
+	assert.Zero(t, o.resubscribeJitter, "the resubscriptions aren't delayed by default")
+
+	assert.NoError(t, WithResubscribeJitter(5*time.Second)(o), "resubscribe jitter configured without error")
+	assert.Equal(t, 5*time.Second, o.resubscribeJitter, "the resubscribe jitter is set")
+
+	assert.Error(t, WithResubscribeJitter(-time.Second)(o), "negative jitter is rejected")
+}
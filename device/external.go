@@ -0,0 +1,123 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	"github.com/eclipse/paho.mqtt.golang"
+)
+
+// ownClientTokensCapacity the number of the latest client tokens issued by the Thing which are remembered to recognize
+// the responses to its own requests
+const ownClientTokensCapacity = 1024
+
+// clientTokenLog remembers the latest client tokens, the oldest ones are forgotten once the capacity is reached
+type clientTokenLog struct {
+	mu     sync.Mutex
+	tokens map[string]struct{}
+	order  []string
+}
+
+// record remembers the client token
+func (l *clientTokenLog) record(token string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.tokens == nil {
+		l.tokens = make(map[string]struct{}, ownClientTokensCapacity)
+	}
+	if _, ok := l.tokens[token]; ok {
+		return
+	}
+	if len(l.order) == ownClientTokensCapacity {
+		delete(l.tokens, l.order[0])
+		l.order = l.order[1:]
+	}
+	l.tokens[token] = struct{}{}
+	l.order = append(l.order, token)
+}
+
+// contains reports whether the client token is remembered
+func (l *clientTokenLog) contains(token string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.tokens[token]
+	return ok
+}
+
+// withOwnClientToken returns the shadow update carrying the client token remembered as the Thing own one, so its
+// accepted response is recognized by SubscribeForExternalShadowChanges. The update is tagged only once the external
+// changes are subscribed, otherwise it's returned as is. The client token set by the caller is kept, the payload which
+// isn't a JSON object is returned as is. The client token is inserted in front of the other fields, so their order and
+// formatting are kept
+func (t *Thing) withOwnClientToken(payload Shadow) (Shadow, error) {
+	if atomic.LoadInt32(&t.externalChanges) == 0 {
+		return payload, nil
+	}
+
+	update := struct {
+		ClientToken *string `json:"clientToken"`
+	}{}
+	if err := json.Unmarshal(payload, &update); err != nil {
+		return payload, nil
+	}
+	if update.ClientToken != nil {
+		t.ownClientTokens.record(*update.ClientToken)
+		return payload, nil
+	}
+
+	fields := bytes.TrimSpace(payload)
+	if len(fields) == 0 || fields[0] != '{' {
+		return payload, nil
+	}
+	fields = bytes.TrimSpace(fields[1:])
+
+	clientToken, err := t.newShadowClientToken()
+	if err != nil {
+		return nil, err
+	}
+	field, err := json.Marshal(clientToken)
+	if err != nil {
+		return nil, err
+	}
+
+	tagged := append([]byte(`{"clientToken":`), field...)
+	if fields[0] != '}' {
+		tagged = append(tagged, ',')
+	}
+	return append(tagged, fields...), nil
+}
+
+// SubscribeForExternalShadowChanges acts like SubscribeForThingShadowChanges but returns only the accepted updates
+// initiated elsewhere, e.g. the desired state changes made by the cloud or other clients, while the echoes of the
+// updates made by the Thing itself are skipped, so the device reacting to the changes doesn't react to its own reports.
+// The updates are told apart by the client tokens the Thing issues, the update carrying no client token is considered
+// external. The subscription of the accepted topic is shared with the other subscribers of the topic, e.g.
+// SubscribeForThingShadowChanges
+func (t *Thing) SubscribeForExternalShadowChanges() (chan Shadow, error) {
+	atomic.StoreInt32(&t.externalChanges, 1)
+
+	shadowChan := make(chan Shadow, t.opts.subscriptionBufferSize)
+
+	if err := t.subscribe(
+		t.shadowTopic("")+"/update/accepted",
+		t.shadowQoS(),
+		func(client mqtt.Client, msg mqtt.Message) {
+			response := struct {
+				ClientToken string `json:"clientToken"`
+			}{}
+			if err := json.Unmarshal(msg.Payload(), &response); err == nil && response.ClientToken != "" &&
+				t.ownClientTokens.contains(response.ClientToken) {
+				return
+			}
+			shadowChan <- msg.Payload()
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	return shadowChan, nil
+}
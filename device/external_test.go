@@ -0,0 +1,69 @@
+package device
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestClientTokenLog(t *testing.T) {
+	var l clientTokenLog
+	assert.False(t, l.contains("token"), "the empty log contains nothing")
+
+	for i := 0; i <= ownClientTokensCapacity; i++ {
+		l.record(fmt.Sprintf("token-%d", i))
+	}
+	assert.False(t, l.contains("token-0"), "the oldest token is forgotten once the capacity is reached")
+	assert.True(t, l.contains("token-1"), "the following tokens are remembered")
+	assert.True(t, l.contains(fmt.Sprintf("token-%d", ownClientTokensCapacity)), "the latest token is remembered")
+}
+
+func TestThing_WithOwnClientToken(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{})
+	assert.NoError(t, err, "thing instance created without error")
+
+	payload := Shadow(`{"state":{"reported":{"value":1}}}`)
+	tagged, err := thing.withOwnClientToken(payload)
+	assert.NoError(t, err, "update processed without error")
+	assert.Equal(t, payload, tagged, "the update isn't tagged until the external changes are subscribed")
+
+	_, err = thing.SubscribeForExternalShadowChanges()
+	assert.NoError(t, err, "subscription queued without error")
+
+	tagged, err = thing.withOwnClientToken(Shadow(`{"state":{"reported":{"b":1,"a":2}}}`))
+	assert.NoError(t, err, "update tagged without error")
+	update := struct {
+		ClientToken string `json:"clientToken"`
+	}{}
+	assert.NoError(t, json.Unmarshal(tagged, &update), "the tagged update is a JSON object")
+	assert.NotEmpty(t, update.ClientToken, "the update is tagged with the client token")
+	assert.True(t, thing.ownClientTokens.contains(update.ClientToken), "the client token is remembered as the own one")
+	assert.True(t, bytes.HasSuffix(tagged, []byte(`,"state":{"reported":{"b":1,"a":2}}}`)), "the fields keep their order")
+
+	tagged, err = thing.withOwnClientToken(Shadow(`{}`))
+	assert.NoError(t, err, "empty update tagged without error")
+	assert.NoError(t, json.Unmarshal(tagged, &update), "the tagged empty update is a JSON object")
+
+	tagged, err = thing.withOwnClientToken(Shadow(`{"state":{},"clientToken":"custom"}`))
+	assert.NoError(t, err, "update processed without error")
+	assert.Equal(t, Shadow(`{"state":{},"clientToken":"custom"}`), tagged, "the client token of the caller is kept")
+	assert.True(t, thing.ownClientTokens.contains("custom"), "the client token of the caller is remembered")
+
+	tagged, err = thing.withOwnClientToken(Shadow(`not json`))
+	assert.NoError(t, err, "non-object payload processed without error")
+	assert.Equal(t, Shadow(`not json`), tagged, "non-object payload is kept as is")
+}
+
+func TestThing_WithOwnClientToken_RandFailure(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{}, WithRandReader(bytes.NewReader(nil)))
+	assert.NoError(t, err, "thing instance created without error")
+
+	_, err = thing.SubscribeForExternalShadowChanges()
+	assert.NoError(t, err, "subscription queued without error")
+
+	_, err = thing.withOwnClientToken(Shadow(`{"state":{}}`))
+	assert.Error(t, err, "the client token failure isn't hidden")
+}
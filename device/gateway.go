@@ -0,0 +1,77 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// ShadowClient works with the classic shadow of another thing over the MQTT connection of the Thing, e.g. the child
+// device represented by the gateway. The policy of the Thing must allow the shadow topics of the other thing.
+// ShadowClient methods are safe for concurrent use by multiple goroutines
+type ShadowClient struct {
+	thing     *Thing
+	thingName ThingName
+}
+
+var _ ShadowTransport = &ShadowClient{}
+
+// ShadowFor returns the ShadowClient of the thing with the provided name sharing the MQTT connection of the Thing, so
+// the gateway doesn't open a connection per child device
+func (t *Thing) ShadowFor(thingName ThingName) (*ShadowClient, error) {
+	if err := validateThingName(thingName); err != nil {
+		return nil, err
+	}
+
+	return &ShadowClient{
+		thing:     t,
+		thingName: thingName,
+	}, nil
+}
+
+// GetShadowFor returns the current shadow of the thing with the provided name using the MQTT connection of the Thing
+func (t *Thing) GetShadowFor(thingName ThingName) (Shadow, error) {
+	c, err := t.ShadowFor(thingName)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetThingShadow()
+}
+
+// ThingName returns the name of the thing the client works with
+func (c *ShadowClient) ThingName() ThingName {
+	return c.thingName
+}
+
+// GetThingShadow returns the current thing shadow. The request carries a unique client token, so the responses to the
+// concurrent requests for the different things don't get mixed up. In case the thing has no shadow yet the returned
+// error matches ErrNoShadow
+func (c *ShadowClient) GetThingShadow() (Shadow, error) {
+	clientToken, err := c.thing.newShadowClientToken()
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(ShadowGetRequest{ClientToken: clientToken})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the shadow get request: %w", err)
+	}
+
+	return c.thing.shadowRequest(context.Background(), c.thing.shadowTopicFor(c.thingName, ""), "get", clientToken, payload)
+}
+
+// UpdateThingShadow publishes an async message with new thing shadow, which AWS IoT merges into the existing shadow
+// state
+func (c *ShadowClient) UpdateThingShadow(payload Shadow) error {
+	if err := c.thing.validateShadow(payload); err != nil {
+		return err
+	}
+	return c.thing.publish(c.thing.shadowTopicFor(c.thingName, "")+"/update", c.thing.shadowQoS(), false, payload)
+}
+
+// DeleteThingShadow publishes a message to remove the thing shadow and waits for the result. In case shadow delete was
+// rejected the method will return the ShadowRejection error
+func (c *ShadowClient) DeleteThingShadow() error {
+	_, err := c.thing.shadowRequest(context.Background(), c.thing.shadowTopicFor(c.thingName, ""), "delete", "", []byte("{}"))
+	return err
+}
@@ -0,0 +1,103 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ShadowTransport the shadow methods shared by the MQTT Thing and the HTTPS HTTPThing, so the code can switch between
+// the transports
+type ShadowTransport interface {
+	GetThingShadow() (Shadow, error)
+	UpdateThingShadow(payload Shadow) error
+	DeleteThingShadow() error
+}
+
+var (
+	_ ShadowTransport = &Thing{}
+	_ ShadowTransport = &HTTPThing{}
+)
+
+// httpTimeout limits the time of the HTTPS data plane requests of the HTTPThing
+const httpTimeout = 10 * time.Second
+
+// HTTPThing works with the thing shadow over the AWS IoT HTTPS data plane authenticated with the device certificates.
+// It suits the devices which can't keep the long-lived MQTT connection open. HTTPThing methods are safe for concurrent
+// use by multiple goroutines
+type HTTPThing struct {
+	thingName ThingName
+	address   string
+	opts      *options
+	client    *http.Client
+}
+
+// NewHTTPThing returns a new instance of HTTPThing using the device certificates for the authentication. The endpoint
+// is accepted in the same forms as by NewThing, the HTTPS data plane port 8443 is used unless the port is set
+// explicitly. Only the shadow validation options, i.e. WithValidation and WithPayloadLimits, apply to the HTTPThing,
+// so the shadow updates are rejected locally like with the Thing
+func NewHTTPThing(keyPair KeyPair, awsEndpoint string, thingName ThingName, opts ...Option) (*HTTPThing, error) {
+	if err := validateThingName(thingName); err != nil {
+		return nil, err
+	}
+
+	o := defaultOptions()
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, fmt.Errorf("invalid option: %w", err)
+		}
+	}
+
+	host, port, _, err := resolveEndpoint(awsEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(keyPair)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HTTPThing{
+		thingName: thingName,
+		address:   restAddress(host, port),
+		opts:      o,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+			Timeout: httpTimeout,
+		},
+	}, nil
+}
+
+// shadowPath returns the HTTPS data plane path of the classic thing shadow
+func (t *HTTPThing) shadowPath() string {
+	return "/things/" + t.thingName + "/shadow"
+}
+
+// GetThingShadow returns the current thing shadow. In case the thing has no shadow yet the returned error matches
+// ErrNoShadow
+func (t *HTTPThing) GetThingShadow() (Shadow, error) {
+	return restRequest(context.Background(), t.client, t.address, http.MethodGet, t.shadowPath(), nil, nil)
+}
+
+// UpdateThingShadow updates the thing shadow and waits for the result. AWS IoT merges the update into the existing
+// shadow state like for the MQTT update. In case the update was rejected the method returns the ShadowRejection error
+func (t *HTTPThing) UpdateThingShadow(payload Shadow) error {
+	if err := validateShadowWith(t.opts, payload); err != nil {
+		return err
+	}
+	_, err := restRequest(context.Background(), t.client, t.address, http.MethodPost, t.shadowPath(), nil, bytes.NewReader(payload))
+	return err
+}
+
+// DeleteThingShadow removes the thing shadow. In case shadow delete was rejected the method returns the
+// ShadowRejection error
+func (t *HTTPThing) DeleteThingShadow() error {
+	_, err := restRequest(context.Background(), t.client, t.address, http.MethodDelete, t.shadowPath(), nil, nil)
+	return err
+}
@@ -0,0 +1,49 @@
+package device
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestHTTPThing(t *testing.T) {
+	thing, err := NewHTTPThing(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "HTTP thing instance created without error")
+	assert.NotNil(t, thing, "HTTP thing instance is not nil")
+
+	err = thing.UpdateThingShadow(Shadow(`{"state":{"reported":{"transport":"https"}}}`))
+	assert.NoError(t, err, "thing shadow updated over HTTPS without error")
+
+	s, err := thing.GetThingShadow()
+	assert.NoError(t, err, "thing shadow retrieved over HTTPS without error")
+	assert.Contains(t, s.String(), `"transport":"https"`, "the retrieved thing shadow contains the update")
+
+	err = thing.UpdateThingShadow(Shadow("invalid JSON"))
+	assert.Error(t, err, "invalid thing shadow update rejected")
+}
+
+func TestNewHTTPThing_Endpoint(t *testing.T) {
+	_, err := NewHTTPThing(keyPair, "https://example-ats.iot.us-east-1.amazonaws.com:8443/", "thing")
+	assert.False(t, err != nil && strings.Contains(err.Error(), "endpoint"), "the endpoint with the scheme and port is accepted")
+
+	_, err = NewHTTPThing(keyPair, "example-ats.iot.us-east-1.amazonaws.com:0", "thing")
+	assert.Error(t, err, "the endpoint with the invalid port is rejected")
+
+	_, err = NewHTTPThing(keyPair, "example-ats.iot.nowhere.amazonaws.com", "thing")
+	assert.Error(t, err, "the endpoint with the invalid region is rejected")
+
+	assert.Equal(t, "example-ats.iot.us-east-1.amazonaws.com:8443", restAddress("example-ats.iot.us-east-1.amazonaws.com", ""), "the data plane port is used by default")
+	assert.Equal(t, "example.com:443", restAddress("example.com", "443"), "the explicit port is kept")
+}
+
+func TestHTTPThing_ValidateShadow(t *testing.T) {
+	thing := &HTTPThing{thingName: "thing", opts: defaultOptions()}
+	assert.NoError(t, WithValidation()(thing.opts), "the validation enabled without error")
+
+	err := thing.UpdateThingShadow(Shadow(`{"state":{"reported":{"value":"` + strings.Repeat("a", MaxShadowSize) + `"}}}`))
+	assert.True(t, errors.Is(err, ErrPayloadTooLarge), "too large shadow is rejected locally")
+
+	err = thing.UpdateThingShadow(Shadow(`{"reported":{"value":1}}`))
+	assert.Error(t, err, "shadow without state is rejected locally")
+}
@@ -0,0 +1,92 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/eclipse/paho.mqtt.golang"
+)
+
+// subscriptionErrorsBufferSize the capacity of the errors channel returned by SubscribeJSON
+const subscriptionErrorsBufferSize = 16
+
+// MarshalError is returned by PublishJSON when the value can't be marshaled, so it can be told apart from the publish
+// errors with errors.As
+type MarshalError struct {
+	Err error
+}
+
+// Error returns the marshaling error message
+func (e *MarshalError) Error() string {
+	return fmt.Sprintf("failed to marshal the payload: %v", e.Err)
+}
+
+// Unwrap returns the underlying marshaling error
+func (e *MarshalError) Unwrap() error {
+	return e.Err
+}
+
+// PublishJSON marshals the value to JSON and publishes it to the custom topic with the provided QoS. The specified
+// topic argument will be prepended by a prefix "$aws/things/<thing_name>". The marshaling errors are returned as
+// MarshalError
+func (t *Thing) PublishJSON(topic string, v interface{}, qos byte) error {
+	fullTopic := t.thingTopic(topic)
+	if err := validateTopic(fullTopic, false); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return &MarshalError{Err: err}
+	}
+
+	encoded, err := t.encodePayload(payload)
+	if err != nil {
+		return err
+	}
+
+	return t.publish(fullTopic, qos, false, encoded)
+}
+
+// SubscribeJSON subscribes for the custom topic and returns the channel with the topic messages unmarshaled into T.
+// The specified topic argument will be prepended by a prefix "$aws/things/<thing_name>". The messages which fail to be
+// decoded or unmarshaled are skipped and their errors are sent to the errors channel, which is buffered and drops the
+// errors nobody reads, so the malformed messages never stall the subscription
+func SubscribeJSON[T any](t *Thing, topic string) (<-chan T, <-chan error, error) {
+	fullTopic := t.thingTopic(topic)
+	if err := validateTopic(fullTopic, true); err != nil {
+		return nil, nil, err
+	}
+
+	values := make(chan T, t.opts.subscriptionBufferSize)
+	errs := make(chan error, subscriptionErrorsBufferSize)
+	sendErr := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+
+	if err := t.subscribe(
+		fullTopic,
+		t.opts.defaultQoS,
+		func(client mqtt.Client, msg mqtt.Message) {
+			payload, err := t.decodePayload(msg.Payload())
+			if err != nil {
+				sendErr(err)
+				return
+			}
+
+			var v T
+			if err := json.Unmarshal(payload, &v); err != nil {
+				sendErr(fmt.Errorf("failed to unmarshal the message of the topic %s: %w", msg.Topic(), err))
+				return
+			}
+			values <- v
+		},
+	); err != nil {
+		return nil, nil, err
+	}
+
+	return values, errs, nil
+}
@@ -0,0 +1,89 @@
+package device
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// caCertificatePatterns the file names of the root CA certificate bundled into the AWS device package or downloaded
+// following the AWS IoT tutorials, in the order of preference
+var caCertificatePatterns = []string{"root-CA.crt", "AmazonRootCA*.pem"}
+
+// KeyPairFromDir discovers the device certificates in the directory with the layout produced by the AWS IoT device
+// setup: the certificate "*-certificate.pem.crt", the private key "*-private.pem.key" and the root CA certificate
+// "root-CA.crt" or "AmazonRootCA*.pem". Returns an error if any of the files is missing or ambiguous
+func KeyPairFromDir(dir string) (KeyPair, error) {
+	certificatePath, err := findSingleFile(dir, "certificate", "*-certificate.pem.crt")
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	privateKeyPath, err := findSingleFile(dir, "private key", "*-private.pem.key")
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	for _, pattern := range caCertificatePatterns {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		if len(matches) == 0 {
+			continue
+		}
+
+		caPath, err := findSingleFile(dir, "root CA certificate", pattern)
+		if err != nil {
+			return KeyPair{}, err
+		}
+
+		return KeyPair{
+			PrivateKeyPath:    privateKeyPath,
+			CertificatePath:   certificatePath,
+			CACertificatePath: caPath,
+		}, nil
+	}
+
+	return KeyPair{}, fmt.Errorf("no root CA certificate matching %q found in %s", strings.Join(caCertificatePatterns, `" or "`), dir)
+}
+
+// findSingleFile returns the only file in the directory matching the pattern
+func findSingleFile(dir, description, pattern string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the %s: %w", description, err)
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no %s matching %q found in %s", description, pattern, dir)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("ambiguous %s: %s all match %q", description, strings.Join(matches, ", "), pattern)
+	}
+}
+
+// CertificateInfo parses the device certificate without connecting and returns its validity window and subject common
+// name, e.g. to rotate the certificate before it expires
+func (kp KeyPair) CertificateInfo() (notBefore, notAfter time.Time, cn string, err error) {
+	certPEM, err := ioutil.ReadFile(kp.CertificatePath)
+	if err != nil {
+		return time.Time{}, time.Time{}, "", fmt.Errorf("failed to read the certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return time.Time{}, time.Time{}, "", errors.New("no PEM encoded certificate found in CertificatePath")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, time.Time{}, "", fmt.Errorf("failed to parse the certificate: %w", err)
+	}
+
+	return cert.NotBefore, cert.NotAfter, cert.Subject.CommonName, nil
+}
@@ -0,0 +1,116 @@
+package device
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKeyPairFromDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keypair")
+	assert.NoError(t, err, "temporary directory created without error")
+	defer os.RemoveAll(dir)
+
+	touch := func(name string) {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), nil, 0600), "file created without error")
+	}
+
+	touch("abc123-certificate.pem.crt")
+	touch("abc123-public.pem.key")
+
+	_, err = KeyPairFromDir(dir)
+	assert.Error(t, err, "directory without private key is rejected")
+
+	touch("abc123-private.pem.key")
+
+	_, err = KeyPairFromDir(dir)
+	assert.Error(t, err, "directory without root CA certificate is rejected")
+
+	touch("AmazonRootCA1.pem")
+
+	keyPair, err := KeyPairFromDir(dir)
+	assert.NoError(t, err, "key pair discovered without error")
+	assert.Equal(t, KeyPair{
+		PrivateKeyPath:    filepath.Join(dir, "abc123-private.pem.key"),
+		CertificatePath:   filepath.Join(dir, "abc123-certificate.pem.crt"),
+		CACertificatePath: filepath.Join(dir, "AmazonRootCA1.pem"),
+	}, keyPair, "the discovered key pair has the device files")
+
+	touch("def456-certificate.pem.crt")
+
+	_, err = KeyPairFromDir(dir)
+	assert.Error(t, err, "directory with multiple certificates is rejected")
+}
+
+// writeTestKeyPair writes the self-signed certificate with the common name and its private key to the directory, the
+// certificate is used as the CA certificate too
+func writeTestKeyPair(t *testing.T, dir, cn string, notBefore, notAfter time.Time) KeyPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "private key generated without error")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err, "certificate created without error")
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err, "private key marshaled without error")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPair := KeyPair{
+		CertificatePath:   filepath.Join(dir, "cert.pem"),
+		PrivateKeyPath:    filepath.Join(dir, "private.key"),
+		CACertificatePath: filepath.Join(dir, "root.ca.pem"),
+	}
+	assert.NoError(t, ioutil.WriteFile(keyPair.CertificatePath, certPEM, 0600), "certificate written without error")
+	assert.NoError(t, ioutil.WriteFile(keyPair.PrivateKeyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600), "private key written without error")
+	assert.NoError(t, ioutil.WriteFile(keyPair.CACertificatePath, certPEM, 0600), "CA certificate written without error")
+	return keyPair
+}
+
+func TestNewTLSConfig_InvalidCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keypair")
+	assert.NoError(t, err, "temporary directory created without error")
+	defer os.RemoveAll(dir)
+
+	keyPair := writeTestKeyPair(t, dir, "thing", time.Now(), time.Now().Add(time.Hour))
+
+	_, err = newTLSConfig(keyPair)
+	assert.NoError(t, err, "valid CA certificate is accepted")
+
+	assert.NoError(t, ioutil.WriteFile(keyPair.CACertificatePath, []byte("not a certificate"), 0600), "CA certificate written without error")
+	_, err = newTLSConfig(keyPair)
+	assert.EqualError(t, err, "no valid CA certificates found in CACertificatePath", "malformed CA certificate is rejected")
+}
+
+func TestKeyPair_CertificateInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keypair")
+	assert.NoError(t, err, "temporary directory created without error")
+	defer os.RemoveAll(dir)
+
+	notBefore := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	keyPair := writeTestKeyPair(t, dir, "thing", notBefore, notAfter)
+
+	gotNotBefore, gotNotAfter, cn, err := keyPair.CertificateInfo()
+	assert.NoError(t, err, "certificate info read without error")
+	assert.True(t, notBefore.Equal(gotNotBefore), "the validity start is returned")
+	assert.True(t, notAfter.Equal(gotNotAfter), "the validity end is returned")
+	assert.Equal(t, "thing", cn, "the common name is returned")
+
+	assert.NoError(t, ioutil.WriteFile(keyPair.CertificatePath, []byte("not a certificate"), 0600), "certificate written without error")
+	_, _, _, err = keyPair.CertificateInfo()
+	assert.Error(t, err, "malformed certificate is rejected")
+}
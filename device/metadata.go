@@ -0,0 +1,108 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ShadowMetadata the parsed metadata section of the shadow document mirroring the structure of the desired and
+// reported states with the timestamps of the last updates of their fields
+type ShadowMetadata struct {
+	Desired  ShadowFieldMetadata `json:"desired"`
+	Reported ShadowFieldMetadata `json:"reported"`
+}
+
+// ShadowFieldMetadata the metadata of the shadow state field. The leaf fields carry the Unix timestamp of their last
+// update, the objects and arrays carry the metadata of their fields, the array elements are keyed by their indexes
+type ShadowFieldMetadata struct {
+	Timestamp int64
+	Fields    map[string]ShadowFieldMetadata
+}
+
+// UnmarshalJSON parses the field metadata. The object is a leaf if its "timestamp" key holds a number, otherwise the
+// "timestamp" key is the metadata of the state field named so
+func (m *ShadowFieldMetadata) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+
+	if len(b) > 0 && b[0] == '[' {
+		var elements []ShadowFieldMetadata
+		if err := json.Unmarshal(b, &elements); err != nil {
+			return err
+		}
+		m.Fields = make(map[string]ShadowFieldMetadata, len(elements))
+		for i, element := range elements {
+			m.Fields[strconv.Itoa(i)] = element
+		}
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+
+	if raw, ok := fields["timestamp"]; ok && len(fields) == 1 {
+		if timestamp, err := strconv.ParseInt(string(bytes.TrimSpace(raw)), 10, 64); err == nil {
+			m.Timestamp = timestamp
+			return nil
+		}
+	}
+
+	m.Fields = make(map[string]ShadowFieldMetadata, len(fields))
+	for name, raw := range fields {
+		field := ShadowFieldMetadata{}
+		if err := json.Unmarshal(raw, &field); err != nil {
+			return err
+		}
+		m.Fields[name] = field
+	}
+	return nil
+}
+
+// Lookup returns the metadata of the nested field with the provided path, e.g. "sensors", "temperature". The array
+// elements are looked up by their indexes
+func (m ShadowFieldMetadata) Lookup(path ...string) (ShadowFieldMetadata, bool) {
+	for _, name := range path {
+		field, ok := m.Fields[name]
+		if !ok {
+			return ShadowFieldMetadata{}, false
+		}
+		m = field
+	}
+	return m, true
+}
+
+// UpdatedAt returns the time of the last update of the field. The time of the object or array is the latest time of
+// its fields. Returns the zero time if the field has no timestamp
+func (m ShadowFieldMetadata) UpdatedAt() time.Time {
+	latest := m.Timestamp
+	for _, field := range m.Fields {
+		if t := field.UpdatedAt(); !t.IsZero() && t.Unix() > latest {
+			latest = t.Unix()
+		}
+	}
+
+	if latest == 0 {
+		return time.Time{}
+	}
+	return time.Unix(latest, 0)
+}
+
+// ParseMetadata parses the metadata section of the shadow document. Returns the empty metadata if the section is absent
+func (d ShadowDocument) ParseMetadata() (ShadowMetadata, error) {
+	m := ShadowMetadata{}
+	if len(d.Metadata) == 0 {
+		return m, nil
+	}
+
+	if err := json.Unmarshal(d.Metadata, &m); err != nil {
+		return ShadowMetadata{}, fmt.Errorf("failed to parse the shadow metadata: %w", err)
+	}
+	return m, nil
+}
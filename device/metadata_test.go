@@ -0,0 +1,46 @@
+package device
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestShadowDocument_ParseMetadata(t *testing.T) {
+	doc := ShadowDocument{}
+	err := json.Unmarshal([]byte(`{
+		"state": {"reported": {"temperature": 21, "sensors": {"humidity": 40, "timestamp": 5}, "leds": [1, 0]}},
+		"metadata": {
+			"desired": null,
+			"reported": {
+				"temperature": {"timestamp": 1600000100},
+				"sensors": {"humidity": {"timestamp": 1600000200}, "timestamp": {"timestamp": 1600000300}},
+				"leds": [{"timestamp": 1600000400}, {"timestamp": 1600000000}]
+			}
+		},
+		"version": 3
+	}`), &doc)
+	assert.NoError(t, err, "shadow document parsed without error")
+
+	m, err := doc.ParseMetadata()
+	assert.NoError(t, err, "shadow metadata parsed without error")
+
+	temperature, ok := m.Reported.Lookup("temperature")
+	assert.True(t, ok, "the leaf field metadata is found")
+	assert.Equal(t, time.Unix(1600000100, 0), temperature.UpdatedAt(), "the leaf field timestamp is returned")
+
+	timestamp, ok := m.Reported.Lookup("sensors", "timestamp")
+	assert.True(t, ok, "the field named timestamp is found")
+	assert.Equal(t, time.Unix(1600000300, 0), timestamp.UpdatedAt(), "the field named timestamp isn't confused with the leaf")
+
+	led, ok := m.Reported.Lookup("leds", "0")
+	assert.True(t, ok, "the array element metadata is found by index")
+	assert.Equal(t, time.Unix(1600000400, 0), led.UpdatedAt(), "the array element timestamp is returned")
+
+	assert.Equal(t, time.Unix(1600000400, 0), m.Reported.UpdatedAt(), "the latest nested timestamp is returned for the object")
+	assert.True(t, m.Desired.UpdatedAt().IsZero(), "the absent section has no timestamp")
+
+	_, ok = m.Reported.Lookup("missing")
+	assert.False(t, ok, "the missing field isn't found")
+}
@@ -0,0 +1,44 @@
+package device
+
+import (
+	"time"
+)
+
+// Metrics receives the counts and latencies of the MQTT operations performed by the Thing. It allows bridging the SDK
+// events to any metrics library, e.g. Prometheus, without the SDK depending on it. The implementation must be safe for
+// concurrent use and must not block
+type Metrics interface {
+	// IncPublish is called after every publish attempt
+	IncPublish(topic string)
+	// IncPublishError is called after every failed publish
+	IncPublishError(topic string)
+	// IncSubscribeError is called after every failed subscribe
+	IncSubscribeError(topic string)
+	// IncReconnect is called after every successful reconnection, either the automatic one or the manual one made with
+	// Reconnect or RotateCredentials
+	IncReconnect()
+	// IncShadowRejection is called for every message received on the shadow rejected topics
+	IncShadowRejection()
+	// ObservePublishLatency is called with the time spent waiting for the publish delivery token
+	ObservePublishLatency(d time.Duration)
+}
+
+// noopMetrics the Metrics implementation used by default, which ignores all the events
+type noopMetrics struct{}
+
+func (noopMetrics) IncPublish(string)                   {}
+func (noopMetrics) IncPublishError(string)              {}
+func (noopMetrics) IncSubscribeError(string)            {}
+func (noopMetrics) IncReconnect()                       {}
+func (noopMetrics) IncShadowRejection()                 {}
+func (noopMetrics) ObservePublishLatency(time.Duration) {}
+
+// observePublish records the metrics of the publish started at the provided time
+func (t *Thing) observePublish(topic string, start time.Time, err error) {
+	m := t.opts.metrics
+	m.IncPublish(topic)
+	m.ObservePublishLatency(t.opts.clock.Now().Sub(start))
+	if err != nil {
+		m.IncPublishError(topic)
+	}
+}
@@ -0,0 +1,35 @@
+package device
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+)
+
+type countingMetrics struct {
+	noopMetrics
+	publishes int32
+}
+
+func (m *countingMetrics) IncPublish(string) {
+	atomic.AddInt32(&m.publishes, 1)
+}
+
+func TestNewThing_WithMetrics(t *testing.T) {
+	metrics := &countingMetrics{}
+
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName, WithMetrics(metrics))
+	assert.NoError(t, err, "thing instance with metrics created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	err = thing.PublishToCustomTopic(Shadow(`{"value":1}`), "metrics")
+	assert.NoError(t, err, "thing shadow published to custom topic without error")
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&metrics.publishes), "the publish has been counted")
+
+	_, err = NewThingAndConnect(keyPair, endpoint, thingName, WithMetrics(nil))
+	assert.Error(t, err, "thing instance with nil metrics is not created")
+}
+
+var _ Metrics = &countingMetrics{}
@@ -0,0 +1,124 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrMQTT5Unsupported is returned when the MQTT 5 feature is requested, as the underlying MQTT client speaks MQTT 3.1.1
+// only. The request fails rather than the feature being dropped silently, so the caller decides on the fallback
+var ErrMQTT5Unsupported = errors.New("the MQTT client doesn't support MQTT 5")
+
+// errUserProperties the error of the connect and publish user properties
+var errUserProperties = fmt.Errorf("user properties require MQTT 5: %w", ErrMQTT5Unsupported)
+
+// errMessageExpiry the error of the message expiry interval
+var errMessageExpiry = fmt.Errorf("message expiry requires MQTT 5: %w", ErrMQTT5Unsupported)
+
+// userProperty the MQTT 5 user property
+type userProperty struct {
+	key   string
+	value string
+}
+
+// WithConnectUserProperty adds the user property to the MQTT connect packet, e.g. to pass the device metadata to the
+// AWS IoT rules. The property may be added multiple times with the same key. The user properties require MQTT 5, so
+// until the MQTT client supports it NewThing fails with the error wrapping ErrMQTT5Unsupported
+func WithConnectUserProperty(key, value string) Option {
+	return func(o *options) error {
+		if key == "" {
+			return errors.New("user property key must not be empty")
+		}
+
+		o.connectUserProperties = append(o.connectUserProperties, userProperty{key: key, value: value})
+		return nil
+	}
+}
+
+// PublishOption configures the single publish made with PublishWithOptions
+type PublishOption func(*publishOptions) error
+
+// publishOptions holds the optional configuration of the single publish
+type publishOptions struct {
+	retained       bool
+	userProperties []userProperty
+	expiry         time.Duration
+}
+
+// WithRetained publishes the message as the retained one
+func WithRetained() PublishOption {
+	return func(o *publishOptions) error {
+		o.retained = true
+		return nil
+	}
+}
+
+// WithUserProperty adds the user property to the published message. The property may be added multiple times with the
+// same key. The user properties require MQTT 5, so until the MQTT client supports it the publish fails with the error
+// wrapping ErrMQTT5Unsupported
+func WithUserProperty(key, value string) PublishOption {
+	return func(o *publishOptions) error {
+		if key == "" {
+			return errors.New("user property key must not be empty")
+		}
+
+		o.userProperties = append(o.userProperties, userProperty{key: key, value: value})
+		return nil
+	}
+}
+
+// WithMessageExpiry sets the message expiry interval, so the broker drops the message not delivered within the interval,
+// e.g. the command queued for the disconnected device which becomes stale. The message expiry requires MQTT 5, so until
+// the MQTT client supports it the publish fails with the error wrapping ErrMQTT5Unsupported
+func WithMessageExpiry(expiry time.Duration) PublishOption {
+	return func(o *publishOptions) error {
+		if expiry <= 0 {
+			return fmt.Errorf("invalid message expiry %s: must be positive", expiry)
+		}
+
+		o.expiry = expiry
+		return nil
+	}
+}
+
+// PublishWithOptions publishes the payload to the topic configured with the publish options and waits for the
+// delivery. Like PublishAsync, the topic is used as is without any prefix. The options requiring MQTT 5 fail the
+// publish with the error wrapping ErrMQTT5Unsupported before anything is published
+func (t *Thing) PublishWithOptions(topic string, payload []byte, qos byte, opts ...PublishOption) error {
+	o := &publishOptions{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return fmt.Errorf("invalid publish option: %w", err)
+		}
+	}
+	if err := o.checkMQTT5(); err != nil {
+		return err
+	}
+
+	if err := validateTopic(topic, false); err != nil {
+		return err
+	}
+	return t.publish(topic, qos, o.retained, payload)
+}
+
+// checkMQTT5 returns the error if any of the options requires MQTT 5
+func (o *publishOptions) checkMQTT5() error {
+	if len(o.userProperties) > 0 {
+		return errUserProperties
+	}
+	if o.expiry > 0 {
+		return errMessageExpiry
+	}
+	return nil
+}
+
+// PublishWithExpiry publishes the payload to the topic with the message expiry interval, so the broker drops the message
+// not delivered within the interval. Like PublishAsync, the topic is used as is without any prefix. The message expiry
+// requires MQTT 5, while the MQTT client speaks MQTT 3.1.1 only, so the method fails with the error wrapping
+// ErrMQTT5Unsupported without publishing anything. The message isn't published without the expiry as the broker would
+// then deliver it regardless of its age, so on MQTT 3.1.1 the stale messages should carry their own expiration time in
+// the payload and be dropped by the receiver
+func (t *Thing) PublishWithExpiry(topic string, payload []byte, qos byte, expiry time.Duration) error {
+	return t.PublishWithOptions(topic, payload, qos, WithMessageExpiry(expiry))
+}
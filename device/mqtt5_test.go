@@ -0,0 +1,45 @@
+package device
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithConnectUserProperty(t *testing.T) {
+	_, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{}, WithConnectUserProperty("firmware", "1.2.3"))
+	assert.True(t, errors.Is(err, ErrMQTT5Unsupported), "connect user properties require MQTT 5")
+	if assert.Error(t, err, "connect user properties are rejected") {
+		assert.True(t, strings.Contains(err.Error(), "user properties require MQTT 5"), "the error names the unsupported feature")
+	}
+
+	assert.Error(t, WithConnectUserProperty("", "value")(defaultOptions()), "empty key is rejected")
+}
+
+func TestThing_PublishWithOptions_UserProperty(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{})
+	assert.NoError(t, err, "thing instance created without error")
+
+	err = thing.PublishWithOptions("topic", []byte("payload"), 1, WithUserProperty("trace-id", "42"))
+	assert.True(t, errors.Is(err, ErrMQTT5Unsupported), "publish user properties require MQTT 5")
+
+	err = thing.PublishWithOptions("topic", []byte("payload"), 1, WithUserProperty("", "42"))
+	assert.Error(t, err, "empty key is rejected")
+	assert.False(t, errors.Is(err, ErrMQTT5Unsupported), "invalid option is reported as such")
+}
+
+func TestThing_PublishWithExpiry(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{}, WithOfflineQueueing(false))
+	assert.NoError(t, err, "thing instance created without error")
+
+	err = thing.PublishWithExpiry("commands", []byte("payload"), 1, time.Minute)
+	assert.True(t, errors.Is(err, ErrMQTT5Unsupported), "message expiry requires MQTT 5")
+	assert.False(t, errors.Is(err, ErrNotConnected), "nothing is published without the expiry")
+
+	err = thing.PublishWithExpiry("commands", []byte("payload"), 1, 0)
+	assert.Error(t, err, "non-positive expiry is rejected")
+	assert.False(t, errors.Is(err, ErrMQTT5Unsupported), "invalid expiry is reported as such")
+}
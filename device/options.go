@@ -0,0 +1,539 @@
+package device
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kuzemkon/aws-iot-device-sdk-go/clock"
+)
+
+// Option configures the Thing created by NewThing
+type Option func(*options) error
+
+// options holds the optional Thing configuration
+type options struct {
+	will          *will
+	autoReconnect bool
+	metrics       Metrics
+	proxyURL      *url.URL
+	// tlsSessionCacheSize is the capacity of the TLS session cache, zero disables the session resumption
+	tlsSessionCacheSize int
+	validateShadows     bool
+	maxShadowSize       int
+	maxMessageSize      int
+	shadowCache         bool
+	codec               Codec
+	topicPrefix         string
+	defaultQoS          byte
+	clientTokenPrefix   string
+	randReader          io.Reader
+	clock               clock.Clock
+	orderedDelivery     bool
+	keepAlive           time.Duration
+	pingTimeout         time.Duration
+	writeTimeout        time.Duration
+	tlsHandshakeTimeout time.Duration
+	keyLogWriter        io.Writer
+	maxInflight         int
+
+	// subscriptionBufferSize is the capacity of the channels returned by the subscription methods
+	subscriptionBufferSize int
+
+	// shadow is set by WithShadowOptions, otherwise the shadow operations follow the options of the whole thing
+	shadow *ShadowOptions
+
+	// offlineQueueing lets the publishes made while disconnected be passed to the MQTT client instead of failing
+	offlineQueueing bool
+
+	// persistentShadowSubscriptions keeps the classic shadow response topics subscribed for the life of the Thing
+	persistentShadowSubscriptions bool
+
+	// connectUserProperties are set by WithConnectUserProperty
+	connectUserProperties []userProperty
+
+	connectRetry         bool
+	connectRetryInterval time.Duration
+
+	// username and password are set by NewThingWithCustomAuth
+	username string
+	password string
+	// sigV4 is set by NewThingWithWebSocket
+	sigV4 *sigV4Signer
+
+	initialReconnectInterval time.Duration
+	maxReconnectInterval     time.Duration
+	reconnectBackoff         func(attempt int) time.Duration
+	resubscribeJitter        time.Duration
+}
+
+// DefaultTopicPrefix the prefix of the thing topics reserved by AWS IoT
+const DefaultTopicPrefix = "$aws/things"
+
+// DefaultTLSHandshakeTimeout the default limit of the TLS handshake with AWS IoT
+const DefaultTLSHandshakeTimeout = 10 * time.Second
+
+// DefaultTLSSessionCacheSize the default capacity of the TLS session cache used to resume the sessions on reconnects
+const DefaultTLSSessionCacheSize = 4
+
+const (
+	// DefaultInitialReconnectInterval the default delay of the first reconnection attempt after the connection loss
+	DefaultInitialReconnectInterval = 1 * time.Second
+	// DefaultMaxReconnectInterval the default upper bound of the delay between the reconnection attempts
+	DefaultMaxReconnectInterval = 2 * time.Minute
+)
+
+// defaultOptions returns the options used when no Option is provided
+func defaultOptions() *options {
+	return &options{
+		autoReconnect:       true,
+		metrics:             noopMetrics{},
+		tlsSessionCacheSize: DefaultTLSSessionCacheSize,
+		topicPrefix:         DefaultTopicPrefix,
+		orderedDelivery:     true,
+		clock:               clock.Real{},
+		randReader:          rand.Reader,
+		tlsHandshakeTimeout: DefaultTLSHandshakeTimeout,
+		maxShadowSize:       MaxShadowSize,
+		maxMessageSize:      MaxMessageSize,
+
+		offlineQueueing: true,
+
+		initialReconnectInterval: DefaultInitialReconnectInterval,
+		maxReconnectInterval:     DefaultMaxReconnectInterval,
+	}
+}
+
+// will the Last Will and Testament message configuration
+type will struct {
+	topic    string
+	payload  []byte
+	qos      byte
+	retained bool
+}
+
+// WithWill configures the Last Will and Testament message which AWS IoT publishes on behalf of the device in case it
+// disconnects ungracefully. The topic is used as is and isn't prepended by the thing prefix
+func WithWill(topic string, payload []byte, qos byte, retained bool) Option {
+	return func(o *options) error {
+		if topic == "" {
+			return errors.New("will topic must not be empty")
+		}
+		if len(payload) == 0 {
+			return errors.New("will payload must not be empty")
+		}
+
+		o.will = &will{
+			topic:    topic,
+			payload:  payload,
+			qos:      qos,
+			retained: retained,
+		}
+		return nil
+	}
+}
+
+// WithAutoReconnect enables or disables the automatic reconnection after the connection loss, which is enabled by
+// default. When disabled, the connection stays down until the Thing is recreated, which gives the full control over
+// the recovery, e.g. to refresh the rotated certificates first. The broker doesn't persist the subscriptions across the
+// connections, so the Thing restores the subscriptions it tracks on every reconnection, keeping their channels valid
+func WithAutoReconnect(enabled bool) Option {
+	return func(o *options) error {
+		o.autoReconnect = enabled
+		return nil
+	}
+}
+
+// WithReconnectInterval configures the backoff of the automatic reconnection. The first reconnection attempt is made
+// after the initial interval since the connection loss. The delay between the following failed attempts starts at one
+// second and doubles after each of them up to the max interval. Defaults to DefaultInitialReconnectInterval and
+// DefaultMaxReconnectInterval
+func WithReconnectInterval(initial, max time.Duration) Option {
+	return func(o *options) error {
+		if initial < 0 {
+			return errors.New("initial reconnect interval must not be negative")
+		}
+		if max <= 0 {
+			return errors.New("max reconnect interval must be positive")
+		}
+
+		o.initialReconnectInterval = initial
+		o.maxReconnectInterval = max
+		return nil
+	}
+}
+
+// WithReconnectBackoff configures the delay before every automatic reconnection attempt, e.g. to align the reconnections
+// of a battery powered device to its duty cycle. The backoff is called with the number of the attempt since the
+// connection loss starting from 1 and replaces WithReconnectInterval. The MQTT client's built-in backoff is reduced to
+// its minimum, though it still waits one second after the first failed attempt in addition to the backoff delay
+func WithReconnectBackoff(backoff func(attempt int) time.Duration) Option {
+	return func(o *options) error {
+		if backoff == nil {
+			return errors.New("reconnect backoff must not be nil")
+		}
+
+		o.reconnectBackoff = backoff
+		return nil
+	}
+}
+
+// WithResubscribeJitter delays restoring the subscriptions after every reconnection by a random duration up to the max,
+// which is zero by default, so the devices of a fleet reconnecting at once after an AWS IoT outage don't resubscribe in
+// lockstep. The messages published to the subscribed topics during the delay are missed. The Connected event is emitted
+// after the delay once the subscriptions are restored
+func WithResubscribeJitter(max time.Duration) Option {
+	return func(o *options) error {
+		if max < 0 {
+			return fmt.Errorf("invalid resubscribe jitter %s: must not be negative", max)
+		}
+
+		o.resubscribeJitter = max
+		return nil
+	}
+}
+
+// WithDefaultQoS configures the QoS used by all the publishes and subscriptions of the thing, except the ones which take
+// the QoS explicitly, e.g. SubscribeRaw or PublishBatch. AWS IoT supports only QoS 0 and 1, the default one is 0
+func WithDefaultQoS(qos byte) Option {
+	return func(o *options) error {
+		if qos > 1 {
+			return fmt.Errorf("invalid default QoS %d: must be 0 or 1", qos)
+		}
+
+		o.defaultQoS = qos
+		return nil
+	}
+}
+
+// WithOrderedDelivery enables or disables the in-order delivery of the received messages, which is enabled by default.
+// When enabled, the messages are passed to the subscription channels and handlers one by one in the order of arrival,
+// so a channel nobody reads from stalls the delivery of all the other messages. When disabled, every message is passed
+// from its own goroutine, so the slow readers don't block each other, but the messages of the same subscription may be
+// received out of order
+func WithOrderedDelivery(enabled bool) Option {
+	return func(o *options) error {
+		o.orderedDelivery = enabled
+		return nil
+	}
+}
+
+// WithSubscriptionBufferSize configures the capacity of the channels returned by the subscription methods, which are
+// unbuffered by default, so the bursts of messages are absorbed while the reader is busy. Once the buffer is full the
+// delivery blocks until the reader catches up, so no message is dropped, but with WithOrderedDelivery enabled the full
+// buffer stalls the delivery of all the other messages as the unbuffered channel does
+func WithSubscriptionBufferSize(n int) Option {
+	return func(o *options) error {
+		if n < 0 {
+			return errors.New("subscription buffer size must not be negative")
+		}
+
+		o.subscriptionBufferSize = n
+		return nil
+	}
+}
+
+const (
+	// MinKeepAlive the minimum keepalive interval accepted by AWS IoT
+	MinKeepAlive = 30 * time.Second
+	// MaxKeepAlive the maximum keepalive interval accepted by AWS IoT
+	MaxKeepAlive = 1200 * time.Second
+)
+
+// WithKeepAlive configures the interval of the MQTT keepalive pings, which defaults to 30 seconds. AWS IoT accepts the
+// intervals from MinKeepAlive to MaxKeepAlive and drops the connection after 1.5 intervals without any message. The
+// longer intervals suit the high latency links, e.g. satellite or cellular, the shorter ones detect the dead
+// connections faster
+func WithKeepAlive(d time.Duration) Option {
+	return func(o *options) error {
+		if d < MinKeepAlive || d > MaxKeepAlive {
+			return fmt.Errorf("invalid keepalive %s: must be from %s to %s", d, MinKeepAlive, MaxKeepAlive)
+		}
+
+		o.keepAlive = d
+		return nil
+	}
+}
+
+// WithPingTimeout configures the time of waiting for the ping response before the connection is considered lost,
+// which defaults to 10 seconds
+func WithPingTimeout(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return errors.New("ping timeout must be positive")
+		}
+
+		o.pingTimeout = d
+		return nil
+	}
+}
+
+// WithWriteTimeout limits the time of waiting for the publishes, so a publish on the half-open connection fails with
+// ErrWriteTimeout instead of blocking until the TCP timeout. The timeout applies to all the shadow and custom topic
+// publishes, by default they wait without a limit
+func WithWriteTimeout(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return errors.New("write timeout must be positive")
+		}
+
+		o.writeTimeout = d
+		return nil
+	}
+}
+
+// WithTLSHandshakeTimeout limits the time of the TLS handshake with AWS IoT separately from the TCP connect, which
+// defaults to DefaultTLSHandshakeTimeout, so the devices on a black-holed or captive portal network fail fast instead of
+// waiting for the TCP timeout. It doesn't apply to the WebSocket connections created with NewThingWithWebSocket, their
+// handshake is limited by the MQTT connect timeout
+func WithTLSHandshakeTimeout(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return errors.New("TLS handshake timeout must be positive")
+		}
+
+		o.tlsHandshakeTimeout = d
+		return nil
+	}
+}
+
+// WithKeyLogWriter writes the TLS master secrets of the MQTT connections to the writer in the NSS key log format, so the
+// captured traffic can be decrypted with Wireshark while debugging the handshake or protocol issues. Anyone having the
+// key log can decrypt the whole session including the shadows and credentials it carries, so the option must never be
+// used in production
+func WithKeyLogWriter(w io.Writer) Option {
+	return func(o *options) error {
+		if w == nil {
+			return errors.New("key log writer must not be nil")
+		}
+
+		o.keyLogWriter = w
+		return nil
+	}
+}
+
+// WithOfflineQueueing enables or disables queueing of the messages published while the thing is disconnected, which is
+// enabled by default, so the MQTT client queues the QoS 1 messages published while reconnecting and sends them once
+// reconnected, while the QoS 0 ones are dropped silently. When disabled, such publishes fail fast with ErrNotConnected,
+// e.g. for the devices buffering the messages on their own
+func WithOfflineQueueing(enabled bool) Option {
+	return func(o *options) error {
+		o.offlineQueueing = enabled
+		return nil
+	}
+}
+
+// WithConnectRetry enables or disables retrying the initial connection in case it fails, which is disabled by default.
+// When enabled, the MQTT client keeps retrying in the background until the connection is established or Disconnect is
+// called, which smooths the device startup when the network isn't up yet. Connect returns once connected or once its
+// context is done, in which case the retries go on and the thing gets connected later
+func WithConnectRetry(enabled bool) Option {
+	return func(o *options) error {
+		o.connectRetry = enabled
+		return nil
+	}
+}
+
+// WithConnectRetryInterval configures the delay between the initial connection attempts enabled with WithConnectRetry,
+// which defaults to 30 seconds
+func WithConnectRetryInterval(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return errors.New("connect retry interval must be positive")
+		}
+
+		o.connectRetryInterval = d
+		return nil
+	}
+}
+
+// WithClientTokenPrefix configures the prefix of the client tokens correlating the shadow requests and responses, e.g.
+// the thing name, so the tokens appearing in the AWS IoT logs can be traced back to the device. The prefix is followed
+// by a random UUID, which is used alone by default
+func WithClientTokenPrefix(prefix string) Option {
+	return func(o *options) error {
+		// the prefix is followed by the separator and the 36 characters long UUID
+		if max := maxClientTokenLength - 37; len(prefix) > max {
+			return fmt.Errorf("invalid client token prefix %q: must be up to %d bytes long", prefix, max)
+		}
+
+		o.clientTokenPrefix = prefix
+		return nil
+	}
+}
+
+// WithRandReader configures the source of the random bytes of the client tokens, which defaults to crypto/rand. A
+// deterministic reader makes the generated tokens reproducible. Intended for testing, the reader must be safe for
+// concurrent use if the shadow requests are made concurrently
+func WithRandReader(r io.Reader) Option {
+	return func(o *options) error {
+		if r == nil {
+			return errors.New("rand reader must not be nil")
+		}
+
+		o.randReader = r
+		return nil
+	}
+}
+
+// WithClock configures the Clock measuring the publish write timeouts and the DrainAndDisconnect timeout, which
+// defaults to the system time. Intended for testing
+func WithClock(c clock.Clock) Option {
+	return func(o *options) error {
+		if c == nil {
+			return errors.New("clock must not be nil")
+		}
+
+		o.clock = c
+		return nil
+	}
+}
+
+// WithMaxInflightMessages limits the number of the publishes waiting for the delivery at the same time, which is
+// unlimited by default. The publish exceeding the limit waits until one of the in-flight publishes completes, so the
+// bursts of the high-rate publishers don't pile up in memory. The QoS 0 publishes are in-flight until written to the
+// connection, the QoS 1 ones until acknowledged by the broker. The limit also applies to the publishes resent after the
+// reconnection
+func WithMaxInflightMessages(n int) Option {
+	return func(o *options) error {
+		if n <= 0 {
+			return errors.New("max inflight messages must be positive")
+		}
+
+		o.maxInflight = n
+		return nil
+	}
+}
+
+// WithMetrics configures the Metrics implementation receiving the counts and latencies of the MQTT operations
+func WithMetrics(m Metrics) Option {
+	return func(o *options) error {
+		if m == nil {
+			return errors.New("metrics must not be nil")
+		}
+
+		o.metrics = m
+		return nil
+	}
+}
+
+// WithTLSSessionCache configures the capacity of the TLS session cache, which lets the reconnects resume the previous
+// TLS session instead of performing the full handshake. The cache size defaults to DefaultTLSSessionCacheSize, zero
+// disables the session resumption
+func WithTLSSessionCache(size int) Option {
+	return func(o *options) error {
+		if size < 0 {
+			return errors.New("TLS session cache size must not be negative")
+		}
+
+		o.tlsSessionCacheSize = size
+		return nil
+	}
+}
+
+// WithValidation enables the local validation of the shadow updates with ValidateShadow before publishing them, so the
+// malformed or oversized updates fail instantly instead of being rejected by AWS IoT
+func WithValidation() Option {
+	return func(o *options) error {
+		o.validateShadows = true
+		return nil
+	}
+}
+
+// WithPayloadLimits overrides the size limits of the shadow updates and the published message payloads, which default
+// to MaxShadowSize and MaxMessageSize, e.g. once AWS IoT raises its limits. The payloads exceeding the limits fail with
+// ErrPayloadTooLarge before being published. The message limit applies to the payload encoded by the codec
+func WithPayloadLimits(shadowSize, messageSize int) Option {
+	return func(o *options) error {
+		if shadowSize <= 0 || messageSize <= 0 {
+			return errors.New("payload limits must be positive")
+		}
+
+		o.maxShadowSize = shadowSize
+		o.maxMessageSize = messageSize
+		return nil
+	}
+}
+
+// WithShadowCache enables caching of the classic shadow document returned by GetThingShadow, so the repeated calls are
+// served from memory instead of a round trip to AWS IoT. The cache is invalidated whenever AWS IoT accepts an update or
+// delete of the shadow, whoever made it, and whenever the connection is lost or reestablished. The cached document is
+// stale only for the time the invalidating message takes to arrive, e.g. GetThingShadow called right after another
+// client updated the shadow may still return the previous document. The cache subscribes for the wildcard accepted
+// topic of the shadow, so AWS IoT may deliver the accepted messages twice to the overlapping subscriptions
+func WithShadowCache() Option {
+	return func(o *options) error {
+		o.shadowCache = true
+		return nil
+	}
+}
+
+// WithPersistentShadowSubscriptions keeps the accepted and rejected topics of the classic shadow get, update and delete
+// operations subscribed for the life of the Thing, so the shadow requests don't subscribe and unsubscribe for their
+// response topics on every call. The policy of the thing must allow subscribing to the topics, otherwise the shadow
+// requests fail with ErrSubscriptionRejected. The subscriptions are exact, so the named shadows and the other topics
+// aren't affected
+func WithPersistentShadowSubscriptions() Option {
+	return func(o *options) error {
+		o.persistentShadowSubscriptions = true
+		return nil
+	}
+}
+
+// ShadowOptions configures the shadow operations of the Thing, i.e. the shadow requests, updates and subscriptions,
+// separately from the custom topic messages. The zero value waits for the responses until the context is done, doesn't
+// retry, uses QoS 0 and generates the client tokens without a prefix
+type ShadowOptions struct {
+	// Timeout limits the time of waiting for the response to a single attempt of the shadow request, zero waits until
+	// the context of the request is done
+	Timeout time.Duration
+	// Retries is the number of times the shadow request is republished after the attempt times out. The retries carry
+	// the same client token, though an update may still be applied twice unless it specifies the shadow version
+	Retries int
+	// QoS used by the shadow publishes and subscriptions, AWS IoT supports only QoS 0 and 1
+	QoS byte
+	// ClientTokenPrefix is the prefix of the client tokens generated for the shadow requests
+	ClientTokenPrefix string
+}
+
+// WithShadowOptions configures the shadow operations with the ShadowOptions. Without the option the shadow operations
+// use the QoS configured with WithDefaultQoS and the client token prefix configured with WithClientTokenPrefix, wait
+// for the responses until the context is done and aren't retried
+func WithShadowOptions(so ShadowOptions) Option {
+	return func(o *options) error {
+		if so.Timeout < 0 {
+			return fmt.Errorf("invalid shadow timeout %s: must not be negative", so.Timeout)
+		}
+		if so.Retries < 0 {
+			return fmt.Errorf("invalid shadow retries %d: must not be negative", so.Retries)
+		}
+		if so.QoS > 1 {
+			return fmt.Errorf("invalid shadow QoS %d: must be 0 or 1", so.QoS)
+		}
+		// the prefix is followed by the separator and the 36 characters long UUID
+		if max := maxClientTokenLength - 37; len(so.ClientTokenPrefix) > max {
+			return fmt.Errorf("invalid client token prefix %q: must be up to %d bytes long", so.ClientTokenPrefix, max)
+		}
+
+		o.shadow = &so
+		return nil
+	}
+}
+
+// WithTopicPrefix overrides the prefix of all the thing topics, which defaults to DefaultTopicPrefix, e.g. to test
+// against a local broker emulating the shadow protocol. The thing name is still appended to the prefix
+func WithTopicPrefix(prefix string) Option {
+	return func(o *options) error {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if err := validateTopic(prefix, false); err != nil {
+			return fmt.Errorf("invalid topic prefix: %w", err)
+		}
+
+		o.topicPrefix = prefix
+		return nil
+	}
+}
@@ -0,0 +1,24 @@
+package device
+
+// Payload arbitrary message data, e.g. protobuf, CBOR or any other binary telemetry, published to and received from the
+// custom topics. Unlike Shadow it carries no JSON semantics
+type Payload []byte
+
+// String converts the Payload to string
+func (p Payload) String() string {
+	return string(p)
+}
+
+// Publish publishes the payload to the custom topic with the default QoS. The payload is encoded by the codec configured
+// with WithPayloadCodec. The specified topic argument will be prepended by a prefix "$aws/things/<thing_name>"
+func (t *Thing) Publish(topic string, payload Payload) error {
+	return t.publishToCustomTopic(topic, payload)
+}
+
+// Subscribe subscribes for the custom topic and returns the channel with the topic payloads along with the cancel
+// function, which unsubscribes from the topic and closes the channel. It acts like SubscribeForCustomTopic but
+// delivers the payloads as Payload. The specified topic argument will be prepended by a prefix
+// "$aws/things/<thing_name>"
+func (t *Thing) Subscribe(topic string) (<-chan Payload, func() error, error) {
+	return subscribeCustomTopic[Payload](t, topic)
+}
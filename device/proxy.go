@@ -0,0 +1,123 @@
+package device
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"golang.org/x/net/proxy"
+)
+
+// WithProxy routes the MQTT connection through the proxy. The "http" and "https" proxy URLs are used to tunnel the
+// MQTT connection with the HTTP CONNECT method, the "socks5" ones use the SOCKS5 protocol. The credentials provided
+// in the proxy URL user info are used for the proxy authentication. In both cases the TLS session is established
+// end-to-end between the device and AWS IoT, so the proxy never sees the device certificates
+func WithProxy(proxyURL string) Option {
+	return func(o *options) error {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %w", err)
+		}
+
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("unsupported proxy scheme %q, must be one of http, https or socks5", u.Scheme)
+		}
+
+		o.proxyURL = u
+		return nil
+	}
+}
+
+// newProxyConnectionFn returns the MQTT connection function opening the TLS connection to the broker through the proxy
+func newProxyConnectionFn(proxyURL *url.URL, handshakeTimeout time.Duration) mqtt.OpenConnectionFunc {
+	return func(uri *url.URL, opts mqtt.ClientOptions) (net.Conn, error) {
+		dialer := &net.Dialer{Timeout: opts.ConnectTimeout}
+
+		var conn net.Conn
+		var err error
+		if proxyURL.Scheme == "socks5" {
+			conn, err = dialSOCKS5(proxyURL, dialer, uri.Host)
+		} else {
+			conn, err = dialHTTPConnect(proxyURL, dialer, uri.Host)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s through the proxy %s: %w", uri.Host, proxyURL.Host, err)
+		}
+
+		return tlsHandshake(conn, uri, opts.TLSConfig, handshakeTimeout)
+	}
+}
+
+// dialSOCKS5 opens the TCP connection to the address through the SOCKS5 proxy
+func dialSOCKS5(proxyURL *url.URL, dialer *net.Dialer, addr string) (net.Conn, error) {
+	d, err := proxy.FromURL(proxyURL, dialer)
+	if err != nil {
+		return nil, err
+	}
+	return d.Dial("tcp", addr)
+}
+
+// dialHTTPConnect opens the TCP tunnel to the address through the HTTP proxy using the CONNECT method
+func dialHTTPConnect(proxyURL *url.URL, dialer *net.Dialer, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	conn, err := dialer.Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		req.SetBasicAuth(proxyURL.User.Username(), password)
+		req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+		req.Header.Del("Authorization")
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("the proxy responded with the status: %s", resp.Status)
+	}
+
+	return conn, nil
+}
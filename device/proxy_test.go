@@ -0,0 +1,55 @@
+package device
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDialHTTPConnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "proxy listener created without error")
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Method != http.MethodConnect || req.Host != "iot.example.com:8883" {
+			io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+			return
+		}
+
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		io.Copy(conn, conn)
+	}()
+
+	proxyURL, _ := url.Parse("http://" + l.Addr().String())
+
+	conn, err := dialHTTPConnect(proxyURL, &net.Dialer{Timeout: time.Second}, "iot.example.com:8883")
+	assert.NoError(t, err, "tunnel opened without error")
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	assert.NoError(t, err, "written to the tunnel without error")
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	assert.NoError(t, err, "read from the tunnel without error")
+	assert.Equal(t, "ping", string(buf), "the tunnel forwards the data")
+}
+
+func TestWithProxy(t *testing.T) {
+	o := defaultOptions()
+	assert.NoError(t, WithProxy("socks5://127.0.0.1:1080")(o), "socks5 proxy is accepted")
+	assert.Error(t, WithProxy("ftp://127.0.0.1:21")(o), "unsupported proxy scheme is rejected")
+}
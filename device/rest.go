@@ -0,0 +1,150 @@
+package device
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang"
+)
+
+// restPort the port of the AWS IoT HTTPS data plane accepting the device certificates
+const restPort = "8443"
+
+// restAddress returns the address of the HTTPS data plane of the endpoint host, on restPort unless the port is set
+func restAddress(host, port string) string {
+	if port == "" {
+		port = restPort
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// restRequest performs the request to the AWS IoT HTTPS data plane authenticated with the TLS configuration of the
+// MQTT connection, i.e. the device certificates. Returns the response body or the ShadowRejection error built from the
+// error response
+func restRequest(ctx context.Context, client *http.Client, address, method, path string, query url.Values, body io.Reader) ([]byte, error) {
+	u := url.URL{
+		Scheme:   "https",
+		Host:     address,
+		Path:     path,
+		RawQuery: query.Encode(),
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the %s request: %w", path, err)
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to perform the %s request: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s response: %w", path, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		r := &ShadowRejection{}
+		if err := json.Unmarshal(respBody, r); err != nil || r.Code == 0 {
+			r.Code = resp.StatusCode
+			r.Message = string(respBody)
+		}
+		return nil, r
+	}
+
+	return respBody, nil
+}
+
+// ErrNoClientCertificate is returned by the HTTPS data plane requests when the thing isn't connected with a client
+// certificate, e.g. when created with NewThingWithCustomAuth or NewThingWithWebSocket, since the data plane port 8443
+// authenticates the devices with the certificates only
+var ErrNoClientCertificate = errors.New("the HTTPS data plane requires a client certificate")
+
+// restClientCache holds the HTTP client built for the MQTT client, so the connections are reused across the requests
+type restClientCache struct {
+	mu     sync.Mutex
+	mqtt   mqtt.Client
+	client *http.Client
+}
+
+// restClient returns the HTTP client authenticated with the device certificates of the MQTT connection. The client is
+// built once per MQTT client, so it's rebuilt after RotateCredentials replaces the MQTT client and the idle connections
+// of the previous one are closed. Returns ErrNoClientCertificate if the MQTT connection has no client certificate
+func (t *Thing) restClient() (*http.Client, error) {
+	current := t.currentClient()
+
+	t.rest.mu.Lock()
+	defer t.rest.mu.Unlock()
+
+	if t.rest.client != nil && t.rest.mqtt == current {
+		return t.rest.client, nil
+	}
+
+	opts := current.OptionsReader()
+	tlsConfig := opts.TLSConfig()
+	if !hasClientCertificate(tlsConfig) {
+		return nil, ErrNoClientCertificate
+	}
+
+	if t.rest.client != nil {
+		t.rest.client.CloseIdleConnections()
+	}
+	t.rest.mqtt = current
+	t.rest.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig.Clone(),
+		},
+	}
+	return t.rest.client, nil
+}
+
+// hasClientCertificate reports whether the TLS configuration presents a client certificate
+func hasClientCertificate(config *tls.Config) bool {
+	return config != nil && (len(config.Certificates) > 0 || config.GetClientCertificate != nil)
+}
+
+// ListNamedShadows returns the names of all the named shadows of the thing. The shadows are listed with the AWS IoT
+// HTTPS data plane authenticated with the device certificates, so the thing policy must allow the
+// iot:ListNamedShadowsForThing action. Returns ErrNoClientCertificate if the thing isn't connected with a client
+// certificate
+func (t *Thing) ListNamedShadows(ctx context.Context) ([]string, error) {
+	client, err := t.restClient()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	query := url.Values{}
+	for {
+		body, err := restRequest(ctx, client, restAddress(t.endpoint, ""), http.MethodGet, "/api/things/shadow/ListNamedShadowsForThing/"+t.thingName, query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		page := struct {
+			Results   []string `json:"results"`
+			NextToken string   `json:"nextToken"`
+		}{}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse the named shadows list: %w", err)
+		}
+
+		names = append(names, page.Results...)
+		if page.NextToken == "" {
+			return names, nil
+		}
+		query.Set("nextToken", page.NextToken)
+	}
+}
@@ -0,0 +1,33 @@
+package device
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestThing_RestClient(t *testing.T) {
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{{}}}
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", tlsConfig)
+	assert.NoError(t, err, "thing instance created without error")
+
+	client, err := thing.restClient()
+	assert.NoError(t, err, "the HTTP client created without error")
+	reused, _ := thing.restClient()
+	assert.True(t, client == reused, "the HTTP client is reused across the requests")
+
+	thing.client = mqtt.NewClient(thing.newClientOptions(tlsConfig))
+	rebuilt, _ := thing.restClient()
+	assert.False(t, client == rebuilt, "the HTTP client is rebuilt for the new MQTT client")
+}
+
+func TestThing_ListNamedShadows_NoClientCertificate(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{})
+	assert.NoError(t, err, "thing instance created without error")
+
+	_, err = thing.ListNamedShadows(context.Background())
+	assert.True(t, errors.Is(err, ErrNoClientCertificate), "the shadows aren't listed without the client certificate")
+}
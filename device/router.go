@@ -0,0 +1,144 @@
+package device
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang"
+)
+
+// responseRouter dispatches the responses of the concurrent requests sharing the response topic by their client tokens.
+// The response topic is subscribed once while any request awaits it, sharing the subscription with the other
+// subscribers of the topic
+type responseRouter struct {
+	// subscriptionMu serializes the response topic subscriptions, while mu guards the routes only, so the responses are
+	// dispatched without waiting for the broker acknowledging the subscriptions
+	subscriptionMu sync.Mutex
+	mu             sync.Mutex
+	routes         map[string]*responseRoute
+}
+
+// responseRoute holds the requests awaiting the responses on the topic by their client tokens
+type responseRoute struct {
+	waiters map[string]chan []byte
+	// remove removes the route handler from the topic subscription
+	remove func() error
+}
+
+// shadowResponseTopics returns the exact accepted and rejected topics of the classic shadow operations
+func (t *Thing) shadowResponseTopics() []string {
+	var topics []string
+	for _, operation := range []string{"get", "update", "delete"} {
+		for _, result := range []string{"accepted", "rejected"} {
+			topics = append(topics, t.shadowTopic("")+"/"+operation+"/"+result)
+		}
+	}
+	return topics
+}
+
+// subscribePersistentShadowResponses keeps the classic shadow response topics subscribed for the life of the Thing if
+// configured with WithPersistentShadowSubscriptions. The handlers do nothing, they only keep the subscriptions, so the
+// shadow requests join them instead of subscribing on their own
+func (t *Thing) subscribePersistentShadowResponses() error {
+	for _, topic := range t.shadowResponseTopics() {
+		if err := t.subscribe(topic, t.shadowQoS(), func(mqtt.Client, mqtt.Message) {}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// routeResponse passes the response to the request awaiting it on the message topic with the client token the response
+// carries. The responses nobody awaits are dropped
+func (t *Thing) routeResponse(client mqtt.Client, msg mqtt.Message) {
+	response := struct {
+		ClientToken string `json:"clientToken"`
+	}{}
+	if err := json.Unmarshal(msg.Payload(), &response); err != nil || response.ClientToken == "" {
+		return
+	}
+
+	t.responses.mu.Lock()
+	var waiter chan []byte
+	if route, ok := t.responses.routes[msg.Topic()]; ok {
+		waiter = route.waiters[response.ClientToken]
+	}
+	t.responses.mu.Unlock()
+	if waiter == nil {
+		return
+	}
+
+	// the channel is buffered, so the first response is kept and the duplicates delivered to the overlapping
+	// subscriptions are dropped
+	select {
+	case waiter <- msg.Payload():
+	default:
+	}
+}
+
+// awaitResponse registers the request awaiting the response with the client token and adds the route handler to the
+// response topic subscription with the QoS unless another request has already added it
+func (t *Thing) awaitResponse(topic, clientToken string, qos byte) (chan []byte, error) {
+	t.responses.subscriptionMu.Lock()
+	defer t.responses.subscriptionMu.Unlock()
+
+	// the channel is buffered and written without blocking, so the late responses never stall the MQTT client
+	responseChan := make(chan []byte, 1)
+
+	t.responses.mu.Lock()
+	route, ok := t.responses.routes[topic]
+	if !ok {
+		if t.responses.routes == nil {
+			t.responses.routes = make(map[string]*responseRoute)
+		}
+		route = &responseRoute{waiters: make(map[string]chan []byte)}
+		t.responses.routes[topic] = route
+	}
+	route.waiters[clientToken] = responseChan
+	subscribe := route.remove == nil
+	t.responses.mu.Unlock()
+
+	if !subscribe {
+		return responseChan, nil
+	}
+
+	remove, err := t.addHandler(topic, qos, t.routeResponse)
+	if err != nil {
+		t.responses.mu.Lock()
+		delete(route.waiters, clientToken)
+		if len(route.waiters) == 0 {
+			delete(t.responses.routes, topic)
+		}
+		t.responses.mu.Unlock()
+		return nil, err
+	}
+
+	t.responses.mu.Lock()
+	route.remove = remove
+	t.responses.mu.Unlock()
+	return responseChan, nil
+}
+
+// stopAwaitingResponse removes the request awaiting the response with the client token and removes the route handler from
+// the response topic subscription once no request awaits it. The other handlers of the topic aren't affected
+func (t *Thing) stopAwaitingResponse(topic, clientToken string) {
+	t.responses.subscriptionMu.Lock()
+	defer t.responses.subscriptionMu.Unlock()
+
+	t.responses.mu.Lock()
+	route, ok := t.responses.routes[topic]
+	if !ok {
+		t.responses.mu.Unlock()
+		return
+	}
+	delete(route.waiters, clientToken)
+	unused := len(route.waiters) == 0
+	if unused {
+		delete(t.responses.routes, topic)
+	}
+	t.responses.mu.Unlock()
+
+	if unused && route.remove != nil {
+		_ = route.remove()
+	}
+}
@@ -0,0 +1,67 @@
+package device
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestThing_WithPersistentShadowSubscriptions(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{}, WithPersistentShadowSubscriptions())
+	assert.NoError(t, err, "thing instance created without error")
+
+	topics := thing.shadowResponseTopics()
+	assert.Len(t, topics, 6, "the accepted and rejected topics of the get, update and delete operations are subscribed")
+	for _, topic := range topics {
+		assert.Contains(t, thing.subscriptions, topic, "the exact shadow response topic is subscribed")
+	}
+	assert.Len(t, thing.subscriptions, 6, "no wildcard topic is subscribed")
+
+	topic := thing.shadowTopic("") + "/get/accepted"
+	_, err = thing.awaitResponse(topic, "token", 0)
+	assert.NoError(t, err, "response awaited without error")
+	thing.stopAwaitingResponse(topic, "token")
+	assert.Contains(t, thing.subscriptions, topic, "the persistent subscription outlives the request")
+}
+
+func TestThing_AwaitResponse_KeepsSubscription(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{})
+	assert.NoError(t, err, "thing instance created without error")
+
+	topic := thing.thingTopic("rpc", "response")
+	delivered := 0
+	err = thing.subscribe(topic, 0, func(client mqtt.Client, msg mqtt.Message) {
+		delivered++
+	})
+	assert.NoError(t, err, "subscription queued without error")
+
+	_, err = thing.awaitResponse(topic, "token", 0)
+	assert.NoError(t, err, "response awaited without error")
+	assert.Len(t, thing.subscriptions[topic].handlers, 2, "the route shares the existing subscription")
+
+	thing.stopAwaitingResponse(topic, "token")
+	if assert.Contains(t, thing.subscriptions, topic, "the existing subscription is kept") {
+		thing.subscriptions[topic].dispatch(nil, nil)
+		assert.Equal(t, 1, delivered, "the existing handler keeps receiving the messages")
+	}
+}
+
+func TestThing_SubscribeOnce_KeepsSubscription(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{})
+	assert.NoError(t, err, "thing instance created without error")
+
+	_, _, err = thing.SubscribeForCustomTopic("replies")
+	assert.NoError(t, err, "subscription queued without error")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = thing.SubscribeOnce(ctx, "replies")
+	assert.Error(t, err, "the done context stops waiting")
+
+	topic := thing.thingTopic("replies")
+	if assert.Contains(t, thing.subscriptions, topic, "the existing subscription is kept") {
+		assert.Len(t, thing.subscriptions[topic].handlers, 1, "only the handler of SubscribeOnce is removed")
+	}
+}
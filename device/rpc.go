@@ -0,0 +1,48 @@
+package device
+
+import (
+	"context"
+	"fmt"
+)
+
+// Call publishes the request to the request topic and waits for the matching response on the response topic until the
+// context is done, e.g. to send a command to a service and await its result. The payload must be a JSON object, it's
+// published with the generated "clientToken" field, which the responder must copy to the response, so the concurrent
+// calls awaiting the responses on the same topic don't get mixed up. The response topic is subscribed while any call
+// awaits it. The specified topic arguments will be prepended by a prefix "$aws/things/<thing_name>"
+func (t *Thing) Call(ctx context.Context, requestTopic, responseTopic string, payload []byte) ([]byte, error) {
+	fullRequestTopic := t.thingTopic(requestTopic)
+	if err := validateTopic(fullRequestTopic, false); err != nil {
+		return nil, err
+	}
+	fullResponseTopic := t.thingTopic(responseTopic)
+	if err := validateTopic(fullResponseTopic, false); err != nil {
+		return nil, err
+	}
+
+	clientToken, err := t.newClientToken()
+	if err != nil {
+		return nil, err
+	}
+	request, err := withClientToken(payload, clientToken)
+	if err != nil {
+		return nil, err
+	}
+
+	responseChan, err := t.awaitResponse(fullResponseTopic, clientToken, t.opts.defaultQoS)
+	if err != nil {
+		return nil, err
+	}
+	defer t.stopAwaitingResponse(fullResponseTopic, clientToken)
+
+	if err := t.publishContext(ctx, fullRequestTopic, t.opts.defaultQoS, false, request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-responseChan:
+		return response, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to wait for the response on %s: %w", fullResponseTopic, ctx.Err())
+	}
+}
@@ -0,0 +1,673 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	// ErrNoDelta is returned when the desired shadow state doesn't differ from the reported one
+	ErrNoDelta = errors.New("the shadow has no delta")
+	// ErrNoShadow matches the ShadowRejection caused by the absence of the thing shadow, e.g. on the first boot before
+	// any shadow update, so it can be checked with errors.Is
+	ErrNoShadow = errors.New("the shadow doesn't exist")
+)
+
+// ShadowRejection represents the error response published by AWS IoT to the shadow rejected topics
+type ShadowRejection struct {
+	Code        int    `json:"code"`
+	Message     string `json:"message"`
+	Timestamp   int64  `json:"timestamp"`
+	ClientToken string `json:"clientToken,omitempty"`
+}
+
+// Error returns the rejection code and message
+func (r *ShadowRejection) Error() string {
+	return fmt.Sprintf("shadow request rejected with code %d: %s", r.Code, r.Message)
+}
+
+// NotFound reports whether the rejection was caused by the absence of the thing shadow
+func (r *ShadowRejection) NotFound() bool {
+	return r.Code == http.StatusNotFound
+}
+
+// Conflict reports whether the rejection was caused by the version conflict of the conditional update
+func (r *ShadowRejection) Conflict() bool {
+	return r.Code == http.StatusConflict
+}
+
+// Is reports whether the rejection matches the target error, the rejection with the 404 code matches ErrNoShadow
+func (r *ShadowRejection) Is(target error) bool {
+	return target == ErrNoShadow && r.NotFound()
+}
+
+// parseShadowRejection parses the rejected topic payload into the ShadowRejection. Falls back to the plain error
+// containing the payload in case it doesn't match the rejection model
+func parseShadowRejection(payload []byte) error {
+	r := &ShadowRejection{}
+	if err := json.Unmarshal(payload, r); err != nil || r.Code == 0 {
+		return errors.New(string(payload))
+	}
+	return r
+}
+
+// ShadowState the state section of the shadow document
+type ShadowState struct {
+	Desired  json.RawMessage `json:"desired,omitempty"`
+	Reported json.RawMessage `json:"reported,omitempty"`
+	Delta    json.RawMessage `json:"delta,omitempty"`
+}
+
+// ShadowDocument the parsed shadow document returned by AWS IoT
+type ShadowDocument struct {
+	State       ShadowState     `json:"state"`
+	Metadata    json.RawMessage `json:"metadata,omitempty"`
+	Version     int             `json:"version"`
+	Timestamp   int64           `json:"timestamp"`
+	ClientToken string          `json:"clientToken,omitempty"`
+}
+
+// ShadowDelta the parsed message of the shadow update documents topic, containing the shadow documents before and
+// after the update
+type ShadowDelta struct {
+	Previous    ShadowDocument `json:"previous"`
+	Current     ShadowDocument `json:"current"`
+	Timestamp   int64          `json:"timestamp"`
+	ClientToken string         `json:"clientToken,omitempty"`
+}
+
+// ShadowGetRequest the body of the shadow get request
+type ShadowGetRequest struct {
+	// ClientToken is returned in the response, so it can be matched with the request
+	ClientToken string `json:"clientToken,omitempty"`
+}
+
+// shadowTopic returns the base topic of the thing shadow. The classic shadow is used if the shadow name is empty
+func (t *Thing) shadowTopic(shadowName string) string {
+	return t.shadowTopicFor(t.thingName, shadowName)
+}
+
+// shadowTopicFor acts like shadowTopic but returns the shadow topic of the thing with the provided name
+func (t *Thing) shadowTopicFor(thingName ThingName, shadowName string) string {
+	return t.topicFor(thingName, shadowLevels(shadowName)...)
+}
+
+// maxClientTokenLength the maximum length of the shadow request client token accepted by AWS IoT in bytes
+const maxClientTokenLength = 64
+
+// newClientToken generates a random UUID client token used to correlate the requests and responses. The token is
+// prepended by the prefix configured with WithClientTokenPrefix
+func (t *Thing) newClientToken() (string, error) {
+	return t.newClientTokenWithPrefix(t.opts.clientTokenPrefix)
+}
+
+// newShadowClientToken acts like newClientToken but uses the client token prefix of the shadow operations
+func (t *Thing) newShadowClientToken() (string, error) {
+	if t.opts.shadow != nil {
+		return t.newClientTokenWithPrefix(t.opts.shadow.ClientTokenPrefix)
+	}
+	return t.newClientToken()
+}
+
+// newClientTokenWithPrefix generates a random UUID client token prepended by the prefix unless it's empty. The token is
+// remembered as the Thing own one
+func (t *Thing) newClientTokenWithPrefix(prefix string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(t.opts.randReader, b); err != nil {
+		return "", fmt.Errorf("failed to generate the client token: %w", err)
+	}
+
+	// version 4 and variant bits of the random UUID
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	uuid := fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+
+	token := uuid
+	if prefix != "" {
+		token = prefix + "-" + uuid
+	}
+	t.ownClientTokens.record(token)
+	return token, nil
+}
+
+// shadowQoS returns the QoS of the shadow operations
+func (t *Thing) shadowQoS() byte {
+	if t.opts.shadow != nil {
+		return t.opts.shadow.QoS
+	}
+	return t.opts.defaultQoS
+}
+
+// shadowAttemptContext limits the context by the shadow operation timeout if it's configured with WithShadowOptions
+func (t *Thing) shadowAttemptContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.opts.shadow == nil || t.opts.shadow.Timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, t.opts.shadow.Timeout)
+}
+
+// shadowRequest publishes the payload to the operation topic (e.g. get or delete) of the shadow with the provided base
+// topic and waits for the response on the corresponding accepted or rejected topics until the context is done. The
+// request is retried on the timeout configured with WithShadowOptions. The responses are routed by the client token, so
+// the concurrent requests sharing the topics neither get mixed up nor unsubscribe each other. If the client token is
+// empty a new one is generated and set in the payload. Returns the accepted response payload or the ShadowRejection
+// error
+func (t *Thing) shadowRequest(ctx context.Context, shadowTopic, operation, clientToken string, payload []byte) (Shadow, error) {
+	operationTopic := fmt.Sprintf("%s/%s", shadowTopic, operation)
+	acceptedTopic := operationTopic + "/accepted"
+	rejectedTopic := operationTopic + "/rejected"
+
+	if clientToken == "" {
+		var err error
+		if clientToken, err = t.newShadowClientToken(); err != nil {
+			return nil, err
+		}
+		if payload, err = withClientToken(payload, clientToken); err != nil {
+			return nil, err
+		}
+	}
+
+	acceptedChan, err := t.awaitResponse(acceptedTopic, clientToken, t.shadowQoS())
+	if err != nil {
+		return nil, err
+	}
+	defer t.stopAwaitingResponse(acceptedTopic, clientToken)
+
+	rejectedChan, err := t.awaitResponse(rejectedTopic, clientToken, t.shadowQoS())
+	if err != nil {
+		return nil, err
+	}
+	defer t.stopAwaitingResponse(rejectedTopic, clientToken)
+
+	retries := 0
+	if t.opts.shadow != nil {
+		retries = t.opts.shadow.Retries
+	}
+
+	// the retries carry the same client token, so the late response to the previous attempt is accepted as well
+	for attempt := 0; ; attempt++ {
+		if err := t.publish(operationTopic, t.shadowQoS(), false, payload); err != nil {
+			return nil, err
+		}
+
+		attemptCtx, cancel := t.shadowAttemptContext(ctx)
+		select {
+		case s := <-acceptedChan:
+			cancel()
+			return s, nil
+		case rejection := <-rejectedChan:
+			cancel()
+			t.opts.metrics.IncShadowRejection()
+			return nil, parseShadowRejection(rejection)
+		case <-attemptCtx.Done():
+			cancel()
+			if ctx.Err() == nil && attempt < retries {
+				continue
+			}
+			return nil, fmt.Errorf("failed to wait for the shadow %s response: %w", operation, attemptCtx.Err())
+		}
+	}
+}
+
+// GetNamedThingShadow returns the current document of the thing named shadow. The request carries a unique client
+// token, so the responses to the concurrent requests don't get mixed up
+func (t *Thing) GetNamedThingShadow(ctx context.Context, shadowName string) (ShadowDocument, error) {
+	if err := validateShadowName(shadowName); err != nil {
+		return ShadowDocument{}, err
+	}
+
+	clientToken, err := t.newShadowClientToken()
+	if err != nil {
+		return ShadowDocument{}, err
+	}
+
+	payload, err := json.Marshal(ShadowGetRequest{ClientToken: clientToken})
+	if err != nil {
+		return ShadowDocument{}, err
+	}
+
+	s, err := t.shadowRequest(ctx, t.shadowTopic(shadowName), "get", clientToken, payload)
+	if err != nil {
+		return ShadowDocument{}, err
+	}
+
+	doc := ShadowDocument{}
+	if err := json.Unmarshal(s, &doc); err != nil {
+		return ShadowDocument{}, fmt.Errorf("failed to parse the shadow document: %w", err)
+	}
+
+	return doc, nil
+}
+
+// UpdateThingShadowSync publishes the thing shadow update and waits for AWS IoT to accept or reject it until the context
+// is done. The update carries a unique client token, so only its own response is taken into account. Returns the
+// accepted response or the ShadowRejection error
+func (t *Thing) UpdateThingShadowSync(ctx context.Context, payload Shadow) (Shadow, error) {
+	if err := t.validateShadow(payload); err != nil {
+		return nil, err
+	}
+
+	clientToken, err := t.newShadowClientToken()
+	if err != nil {
+		return nil, err
+	}
+
+	update, err := withClientToken(payload, clientToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.shadowRequest(ctx, t.shadowTopic(""), "update", clientToken, update)
+}
+
+// MaxUpdateRetries the maximum number of the shadow update attempts made by UpdateReportedStateWithRetry
+const MaxUpdateRetries = 5
+
+// UpdateReportedStateWithRetry performs the read-modify-write of the reported state. It gets the current shadow, passes
+// its reported state to the mutate function and publishes the returned reported state as the update conditional on the
+// version of the read shadow. In case another writer updated the shadow in between the update is rejected with the
+// version conflict, so the whole cycle is retried up to MaxUpdateRetries times. A missing shadow is passed to the
+// mutate function as the nil state
+func (t *Thing) UpdateReportedStateWithRetry(ctx context.Context, mutate func(current Shadow) Shadow) error {
+	var err error
+	for attempt := 0; attempt < MaxUpdateRetries; attempt++ {
+		if err = t.updateReportedState(ctx, mutate); err == nil {
+			return nil
+		}
+
+		var r *ShadowRejection
+		if !errors.As(err, &r) || !r.Conflict() {
+			return err
+		}
+	}
+	return fmt.Errorf("failed to update the reported state after %d attempts: %w", MaxUpdateRetries, err)
+}
+
+// updateReportedState performs a single read-modify-write attempt of UpdateReportedStateWithRetry
+func (t *Thing) updateReportedState(ctx context.Context, mutate func(current Shadow) Shadow) error {
+	clientToken, err := t.newShadowClientToken()
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(ShadowGetRequest{ClientToken: clientToken})
+	if err != nil {
+		return err
+	}
+
+	doc := ShadowDocument{}
+	s, err := t.shadowRequest(ctx, t.shadowTopic(""), "get", clientToken, payload)
+	switch {
+	case errors.Is(err, ErrNoShadow):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(s, &doc); err != nil {
+			return fmt.Errorf("failed to parse the shadow document: %w", err)
+		}
+	}
+
+	var current Shadow
+	if len(doc.State.Reported) > 0 {
+		current = Shadow(doc.State.Reported)
+	}
+
+	update := struct {
+		State       ShadowState `json:"state"`
+		Version     int         `json:"version,omitempty"`
+		ClientToken string      `json:"clientToken"`
+	}{
+		State:       ShadowState{Reported: json.RawMessage(mutate(current))},
+		Version:     doc.Version,
+		ClientToken: clientToken,
+	}
+
+	payload, err = json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the shadow update: %w", err)
+	}
+
+	_, err = t.shadowRequest(ctx, t.shadowTopic(""), "update", clientToken, payload)
+	return err
+}
+
+// withClientToken sets the client token field of the JSON object payload
+func withClientToken(payload []byte, clientToken string) ([]byte, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil, fmt.Errorf("failed to parse the JSON object payload: %w", err)
+	}
+
+	token, err := json.Marshal(clientToken)
+	if err != nil {
+		return nil, err
+	}
+	fields["clientToken"] = token
+
+	return json.Marshal(fields)
+}
+
+// GetThingShadowDelta returns the current difference between the desired and reported states of the thing shadow. The
+// delta section of the shadow is used if present, otherwise the delta is computed locally. Returns ErrNoDelta if the
+// states don't differ
+func (t *Thing) GetThingShadowDelta() (Shadow, error) {
+	s, err := t.GetThingShadow()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := ShadowDocument{}
+	if err := json.Unmarshal(s, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse the shadow document: %w", err)
+	}
+
+	if len(doc.State.Delta) > 0 {
+		return Shadow(doc.State.Delta), nil
+	}
+
+	var desired, reported map[string]interface{}
+	if len(doc.State.Desired) > 0 {
+		if err := json.Unmarshal(doc.State.Desired, &desired); err != nil {
+			return nil, fmt.Errorf("failed to parse the desired state: %w", err)
+		}
+	}
+	if len(doc.State.Reported) > 0 {
+		if err := json.Unmarshal(doc.State.Reported, &reported); err != nil {
+			return nil, fmt.Errorf("failed to parse the reported state: %w", err)
+		}
+	}
+
+	delta := computeDelta(desired, reported)
+	if len(delta) == 0 {
+		return nil, ErrNoDelta
+	}
+
+	return json.Marshal(delta)
+}
+
+// GetShadowAndDelta returns the reported state and the delta of the thing shadow obtained with a single get request, so
+// the device can reconcile its state on boot without racing the delta subscription. The delta is nil if the desired
+// state doesn't differ from the reported one
+func (t *Thing) GetShadowAndDelta() (reported Shadow, delta Shadow, err error) {
+	s, err := t.GetThingShadow()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc := ShadowDocument{}
+	if err := json.Unmarshal(s, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse the shadow document: %w", err)
+	}
+
+	if len(doc.State.Reported) > 0 {
+		reported = Shadow(doc.State.Reported)
+	}
+	if len(doc.State.Delta) > 0 {
+		delta = Shadow(doc.State.Delta)
+	}
+	return reported, delta, nil
+}
+
+// GetShadowState returns the desired and the reported states of the thing shadow obtained with a single get request,
+// so the device interested in one side doesn't have to parse the whole shadow document. The absent sections are
+// returned empty
+func (t *Thing) GetShadowState() (desired, reported Shadow, err error) {
+	s, err := t.GetThingShadow()
+	if err != nil {
+		return nil, nil, err
+	}
+	return splitShadowState(s)
+}
+
+// splitShadowState extracts the desired and the reported states from the shadow document. The absent and null sections
+// are returned empty
+func splitShadowState(s Shadow) (desired, reported Shadow, err error) {
+	doc := ShadowDocument{}
+	if err := json.Unmarshal(s, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse the shadow document: %w", err)
+	}
+
+	if len(doc.State.Desired) > 0 && string(doc.State.Desired) != "null" {
+		desired = Shadow(doc.State.Desired)
+	}
+	if len(doc.State.Reported) > 0 && string(doc.State.Reported) != "null" {
+		reported = Shadow(doc.State.Reported)
+	}
+	return desired, reported, nil
+}
+
+// computeDelta returns the desired state fields which are missing or differ in the reported state. The nested objects
+// are compared recursively, so only the differing nested fields are returned
+func computeDelta(desired, reported map[string]interface{}) map[string]interface{} {
+	delta := make(map[string]interface{})
+	for k, d := range desired {
+		r, ok := reported[k]
+		if !ok {
+			delta[k] = d
+			continue
+		}
+
+		dObj, dIsObj := d.(map[string]interface{})
+		rObj, rIsObj := r.(map[string]interface{})
+		if dIsObj && rIsObj {
+			if nested := computeDelta(dObj, rObj); len(nested) > 0 {
+				delta[k] = nested
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(d, r) {
+			delta[k] = d
+		}
+	}
+	return delta
+}
+
+// SubscribeForThingShadowDocuments subscribes for the shadow update documents topic and returns the channel with the
+// parsed shadow states before and after every accepted update. The messages which fail to parse are skipped
+func (t *Thing) SubscribeForThingShadowDocuments() (chan ShadowDelta, error) {
+	deltaChan := make(chan ShadowDelta, t.opts.subscriptionBufferSize)
+
+	if err := t.subscribe(
+		t.shadowTopic("")+"/update/documents",
+		t.shadowQoS(),
+		func(client mqtt.Client, msg mqtt.Message) {
+			delta := ShadowDelta{}
+			if err := json.Unmarshal(msg.Payload(), &delta); err != nil {
+				return
+			}
+			deltaChan <- delta
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	return deltaChan, nil
+}
+
+// NamedShadowDelta the delta message of the named shadow
+type NamedShadowDelta struct {
+	ShadowName string
+	Payload    Shadow
+}
+
+// SubscribeForAllShadowDeltas subscribes for the delta topics of the provided named shadows and returns a single
+// channel with the deltas of all of them. If no shadow names are provided the wildcard topic is used, so the deltas of
+// all the named shadows of the thing are received
+func (t *Thing) SubscribeForAllShadowDeltas(shadowNames ...string) (chan NamedShadowDelta, error) {
+	for _, shadowName := range shadowNames {
+		if err := validateShadowName(shadowName); err != nil {
+			return nil, err
+		}
+	}
+
+	topics := []string{t.shadowTopic("+") + "/update/delta"}
+	if len(shadowNames) > 0 {
+		topics = make([]string, len(shadowNames))
+		for i, shadowName := range shadowNames {
+			topics[i] = t.shadowTopic(shadowName) + "/update/delta"
+		}
+	}
+
+	// the shadow name is the topic level following the "name" one
+	namePrefix := t.topicFor(t.thingName, "shadow", "name") + "/"
+	deltaChan := make(chan NamedShadowDelta, t.opts.subscriptionBufferSize)
+	handler := func(client mqtt.Client, msg mqtt.Message) {
+		shadowName := strings.TrimPrefix(msg.Topic(), namePrefix)
+		shadowName = strings.TrimSuffix(shadowName, "/update/delta")
+		deltaChan <- NamedShadowDelta{
+			ShadowName: shadowName,
+			Payload:    msg.Payload(),
+		}
+	}
+
+	removes := make([]func() error, 0, len(topics))
+	for _, topic := range topics {
+		remove, err := t.addHandler(topic, t.shadowQoS(), handler)
+		if err != nil {
+			for _, remove := range removes {
+				_ = remove()
+			}
+			return nil, err
+		}
+		removes = append(removes, remove)
+	}
+
+	return deltaChan, nil
+}
+
+// SubscribeForShadowErrors subscribes for the rejected topics of all the classic shadow operations, i.e. get, update
+// and delete, and returns the channel with the parsed rejections, e.g. to detect the policy or client errors in the
+// field. The subscription uses the wildcard topic, so it isn't affected by the shadow requests subscribing for the same
+// rejected topics temporarily. The rejections which don't match the rejection model carry the raw payload in the
+// message
+func (t *Thing) SubscribeForShadowErrors() (chan ShadowRejection, error) {
+	rejectionChan := make(chan ShadowRejection, t.opts.subscriptionBufferSize)
+
+	if err := t.subscribe(
+		t.shadowTopic("")+"/+/rejected",
+		t.shadowQoS(),
+		func(client mqtt.Client, msg mqtt.Message) {
+			r := ShadowRejection{}
+			if err := json.Unmarshal(msg.Payload(), &r); err != nil || r.Code == 0 {
+				r = ShadowRejection{Message: string(msg.Payload())}
+			}
+			rejectionChan <- r
+		},
+	); err != nil {
+		return nil, err
+	}
+
+	return rejectionChan, nil
+}
+
+// OnShadowDelta subscribes for the shadow delta topic and calls the handler with every delta message, which is published
+// by AWS IoT when the desired state differs from the reported one. The handler is called directly by the MQTT client
+// message loop, so it must return quickly and must not block, otherwise the delivery of all the other messages stalls
+func (t *Thing) OnShadowDelta(handler func(Shadow)) error {
+	return t.subscribe(
+		t.shadowTopic("")+"/update/delta",
+		t.shadowQoS(),
+		func(client mqtt.Client, msg mqtt.Message) {
+			handler(msg.Payload())
+		},
+	)
+}
+
+// StripShadowMetadata returns the shadow update containing only the desired and reported states of the shadow document,
+// so the document read from AWS IoT can be published again. The metadata, version, timestamp, client token and delta
+// are removed, since AWS IoT rejects the updates carrying them
+func StripShadowMetadata(s Shadow) (Shadow, error) {
+	doc := ShadowDocument{}
+	if err := json.Unmarshal(s, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse the shadow document: %w", err)
+	}
+
+	return json.Marshal(struct {
+		State ShadowState `json:"state"`
+	}{
+		State: ShadowState{
+			Desired:  doc.State.Desired,
+			Reported: doc.State.Reported,
+		},
+	})
+}
+
+// DeleteShadowField publishes a shadow update deleting the field at the dotted path, e.g. "reported.error" or
+// "desired.config.mode". The path must start with either the desired or reported section
+func (t *Thing) DeleteShadowField(path string) error {
+	update, err := newDeleteFieldUpdate(path)
+	if err != nil {
+		return err
+	}
+	return t.UpdateThingShadow(update)
+}
+
+// newDeleteFieldUpdate builds the shadow update setting the field at the dotted path to null
+func newDeleteFieldUpdate(path string) (Shadow, error) {
+	segments := strings.Split(path, ".")
+	if len(segments) < 2 || (segments[0] != "desired" && segments[0] != "reported") {
+		return nil, fmt.Errorf("invalid shadow field path %q: must start with desired or reported followed by the field name", path)
+	}
+
+	var value interface{}
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segments[i] == "" {
+			return nil, fmt.Errorf("invalid shadow field path %q: must not contain empty segments", path)
+		}
+		value = map[string]interface{}{segments[i]: value}
+	}
+
+	return json.Marshal(map[string]interface{}{"state": value})
+}
+
+// WaitForReportedState waits until the thing shadow satisfies the match function or the context is done. The match
+// function is called with the full current shadow document at first and then again after every accepted shadow update.
+// A missing shadow is treated as not matching. The method temporarily subscribes to the shadow update accepted topic,
+// sharing the subscription with the other subscribers of the topic, e.g. SubscribeForThingShadowChanges
+func (t *Thing) WaitForReportedState(ctx context.Context, match func(Shadow) bool) error {
+	acceptedTopic := t.shadowTopic("") + "/update/accepted"
+
+	// only the fact of the update matters, the full document is fetched after each of them
+	updateChan := make(chan struct{}, 1)
+
+	remove, err := t.addHandler(
+		acceptedTopic,
+		t.shadowQoS(),
+		func(client mqtt.Client, msg mqtt.Message) {
+			select {
+			case updateChan <- struct{}{}:
+			default:
+			}
+		},
+	)
+	if err != nil {
+		return err
+	}
+	defer remove()
+
+	for {
+		s, err := t.shadowRequest(ctx, t.shadowTopic(""), "get", "", []byte("{}"))
+		if err != nil {
+			if !errors.Is(err, ErrNoShadow) {
+				return err
+			}
+		} else if match(s) {
+			return nil
+		}
+
+		select {
+		case <-updateChan:
+		case <-ctx.Done():
+			return fmt.Errorf("failed to wait for the shadow state: %w", ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeDelta(t *testing.T) {
+	var desired, reported map[string]interface{}
+
+	err := json.Unmarshal([]byte(`{"color":"red","power":{"on":true,"level":3},"mode":"auto"}`), &desired)
+	assert.NoError(t, err, "desired state unmarshaled without error")
+
+	err = json.Unmarshal([]byte(`{"color":"red","power":{"on":true,"level":1}}`), &reported)
+	assert.NoError(t, err, "reported state unmarshaled without error")
+
+	delta := computeDelta(desired, reported)
+	assert.Equal(t, map[string]interface{}{
+		"power": map[string]interface{}{"level": float64(3)},
+		"mode":  "auto",
+	}, delta, "only differing fields are in the delta")
+
+	assert.Empty(t, computeDelta(reported, reported), "equal states have no delta")
+}
+
+func TestNewDeleteFieldUpdate(t *testing.T) {
+	update, err := newDeleteFieldUpdate("reported.power.error")
+	assert.NoError(t, err, "delete field update built without error")
+	assert.JSONEq(t, `{"state":{"reported":{"power":{"error":null}}}}`, string(update))
+
+	_, err = newDeleteFieldUpdate("reported")
+	assert.Error(t, err, "path without field is rejected")
+
+	_, err = newDeleteFieldUpdate("metadata.error")
+	assert.Error(t, err, "path outside desired and reported is rejected")
+
+	_, err = newDeleteFieldUpdate("reported..error")
+	assert.Error(t, err, "path with empty segment is rejected")
+}
+
+func TestWithClientToken(t *testing.T) {
+	update, err := withClientToken([]byte(`{"state":{"reported":{"value":1}}}`), "token")
+	assert.NoError(t, err, "client token set without error")
+	assert.JSONEq(t, `{"state":{"reported":{"value":1}},"clientToken":"token"}`, string(update))
+
+	_, err = withClientToken([]byte("invalid JSON"), "token")
+	assert.Error(t, err, "invalid update is rejected")
+}
+
+func TestShadowRejection_Is(t *testing.T) {
+	err := parseShadowRejection([]byte(`{"code":404,"message":"No shadow exists with name: 'thing'"}`))
+	assert.True(t, errors.Is(err, ErrNoShadow), "the not found rejection matches ErrNoShadow")
+
+	err = parseShadowRejection([]byte(`{"code":400,"message":"Missing required node: state"}`))
+	assert.False(t, errors.Is(err, ErrNoShadow), "the bad request rejection doesn't match ErrNoShadow")
+}
+
+func TestThing_ShadowTopic(t *testing.T) {
+	thing := &Thing{thingName: "thing", opts: defaultOptions()}
+	assert.Equal(t, "$aws/things/thing/shadow", thing.shadowTopic(""), "the classic shadow topic uses the default prefix")
+	assert.Equal(t, "$aws/things/thing/shadow/name/config", thing.shadowTopic("config"), "the named shadow topic uses the default prefix")
+
+	err := WithTopicPrefix("local/things/")(thing.opts)
+	assert.NoError(t, err, "topic prefix configured without error")
+	assert.Equal(t, "local/things/thing/shadow", thing.shadowTopic(""), "the shadow topic uses the configured prefix")
+
+	assert.Error(t, WithTopicPrefix("local/+")(thing.opts), "topic prefix with wildcard is rejected")
+}
+
+func TestStripShadowMetadata(t *testing.T) {
+	doc := Shadow(`{
+		"state": {
+			"desired": {"mode": "on"},
+			"reported": {"mode": "off", "value": 1},
+			"delta": {"mode": "on"}
+		},
+		"metadata": {
+			"desired": {"mode": {"timestamp": 1600000000}},
+			"reported": {"mode": {"timestamp": 1600000000}, "value": {"timestamp": 1600000000}}
+		},
+		"version": 42,
+		"timestamp": 1600000001,
+		"clientToken": "token"
+	}`)
+
+	update, err := StripShadowMetadata(doc)
+	assert.NoError(t, err, "shadow metadata stripped without error")
+	assert.JSONEq(t, `{"state":{"desired":{"mode":"on"},"reported":{"mode":"off","value":1}}}`, string(update))
+
+	_, err = StripShadowMetadata(Shadow("invalid JSON"))
+	assert.Error(t, err, "invalid shadow document is rejected")
+}
+
+func TestShadowRejection_Conflict(t *testing.T) {
+	err := parseShadowRejection([]byte(`{"code":409,"message":"Version conflict"}`))
+
+	var r *ShadowRejection
+	assert.True(t, errors.As(err, &r), "the rejection is parsed")
+	assert.True(t, r.Conflict(), "the version conflict rejection is detected")
+	assert.False(t, r.NotFound(), "the version conflict rejection isn't not found")
+}
+
+func TestThing_NewClientToken(t *testing.T) {
+	thing := &Thing{thingName: "thing", opts: defaultOptions()}
+
+	token, err := thing.newClientToken()
+	assert.NoError(t, err, "client token generated without error")
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, token, "the client token is a random UUID")
+
+	err = WithClientTokenPrefix("thing-get")(thing.opts)
+	assert.NoError(t, err, "client token prefix configured without error")
+
+	token, err = thing.newClientToken()
+	assert.NoError(t, err, "prefixed client token generated without error")
+	assert.Regexp(t, `^thing-get-[0-9a-f-]{36}$`, token, "the client token has the prefix")
+	assert.True(t, len(token) <= maxClientTokenLength, "the client token fits the length limit")
+
+	assert.Error(t, WithClientTokenPrefix(strings.Repeat("a", 28))(thing.opts), "too long prefix is rejected")
+}
+
+func TestThing_NewClientToken_RandReader(t *testing.T) {
+	thing := &Thing{thingName: "thing", opts: defaultOptions()}
+
+	err := WithRandReader(strings.NewReader(strings.Repeat("\x00", 16)))(thing.opts)
+	assert.NoError(t, err, "rand reader configured without error")
+
+	token, err := thing.newClientToken()
+	assert.NoError(t, err, "client token generated without error")
+	assert.Equal(t, "00000000-0000-4000-8000-000000000000", token, "the client token is generated from the reader")
+
+	_, err = thing.newClientToken()
+	assert.Error(t, err, "exhausted reader fails the generation")
+
+	assert.Error(t, WithRandReader(nil)(thing.opts), "nil reader is rejected")
+}
+
+func TestThing_ShadowTopicFor(t *testing.T) {
+	thing := &Thing{thingName: "gateway", opts: defaultOptions()}
+	assert.Equal(t, "$aws/things/child/shadow", thing.shadowTopicFor("child", ""), "the shadow topic of the child thing is built")
+	assert.Equal(t, "$aws/things/gateway/shadow", thing.shadowTopic(""), "the shadow topic of the thing itself is kept")
+
+	_, err := thing.ShadowFor("child/+")
+	assert.Error(t, err, "invalid child thing name is rejected")
+}
+
+func TestSplitShadowState(t *testing.T) {
+	desired, reported, err := splitShadowState(Shadow(`{"state":{"desired":{"mode":"on"},"reported":{"mode":"off"}},"version":3}`))
+	assert.NoError(t, err, "shadow state split without error")
+	assert.JSONEq(t, `{"mode":"on"}`, desired.String(), "the desired state is returned")
+	assert.JSONEq(t, `{"mode":"off"}`, reported.String(), "the reported state is returned")
+
+	desired, reported, err = splitShadowState(Shadow(`{"state":{"desired":null,"reported":{"mode":"off"}}}`))
+	assert.NoError(t, err, "shadow state without desired section split without error")
+	assert.Empty(t, desired, "the null desired state is empty")
+	assert.NotEmpty(t, reported, "the reported state is returned")
+
+	desired, reported, err = splitShadowState(Shadow(`{"state":{}}`))
+	assert.NoError(t, err, "empty shadow state split without error")
+	assert.Empty(t, desired, "the absent desired state is empty")
+	assert.Empty(t, reported, "the absent reported state is empty")
+
+	_, _, err = splitShadowState(Shadow(`not json`))
+	assert.Error(t, err, "malformed shadow is rejected")
+}
+
+func TestWithShadowOptions(t *testing.T) {
+	thing := &Thing{thingName: "thing", opts: defaultOptions()}
+	assert.NoError(t, WithDefaultQoS(1)(thing.opts), "default QoS configured without error")
+	assert.NoError(t, WithClientTokenPrefix("device")(thing.opts), "client token prefix configured without error")
+	assert.Equal(t, byte(1), thing.shadowQoS(), "the shadow operations follow the default QoS without the shadow options")
+
+	token, err := thing.newShadowClientToken()
+	assert.NoError(t, err, "shadow client token generated without error")
+	assert.True(t, strings.HasPrefix(token, "device-"), "the shadow client token follows the client token prefix")
+
+	err = WithShadowOptions(ShadowOptions{Timeout: time.Second, Retries: 2, QoS: 0, ClientTokenPrefix: "shadow"})(thing.opts)
+	assert.NoError(t, err, "shadow options configured without error")
+	assert.Equal(t, byte(0), thing.shadowQoS(), "the shadow QoS overrides the default QoS")
+
+	token, err = thing.newShadowClientToken()
+	assert.NoError(t, err, "shadow client token generated without error")
+	assert.True(t, strings.HasPrefix(token, "shadow-"), "the shadow client token uses the shadow prefix")
+
+	token, err = thing.newClientToken()
+	assert.NoError(t, err, "client token generated without error")
+	assert.True(t, strings.HasPrefix(token, "device-"), "the other client tokens keep the client token prefix")
+
+	ctx, cancel := thing.shadowAttemptContext(context.Background())
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.True(t, ok, "the shadow attempt is limited by the timeout")
+
+	assert.Error(t, WithShadowOptions(ShadowOptions{Timeout: -time.Second})(thing.opts), "negative timeout is rejected")
+	assert.Error(t, WithShadowOptions(ShadowOptions{Retries: -1})(thing.opts), "negative retries are rejected")
+	assert.Error(t, WithShadowOptions(ShadowOptions{QoS: 2})(thing.opts), "unsupported QoS is rejected")
+	assert.Error(t, WithShadowOptions(ShadowOptions{ClientTokenPrefix: strings.Repeat("x", 28)})(thing.opts), "too long prefix is rejected")
+}
@@ -0,0 +1,69 @@
+package device
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang"
+)
+
+// shadowCache holds the classic shadow document returned by the latest get request until the shadow changes
+type shadowCache struct {
+	mu  sync.Mutex
+	doc Shadow
+	// generation is incremented on every invalidation, so the get response requested before the invalidation isn't
+	// cached after it
+	generation uint64
+}
+
+// load returns the cached shadow document, which is nil if the cache is empty, and the current generation
+func (c *shadowCache) load() (Shadow, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.doc == nil {
+		return nil, c.generation
+	}
+	return append(Shadow(nil), c.doc...), c.generation
+}
+
+// store caches the shadow document unless the cache has been invalidated since the generation
+func (c *shadowCache) store(doc Shadow, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation == generation {
+		c.doc = append(Shadow(nil), doc...)
+	}
+}
+
+// invalidate drops the cached shadow document. It's a no-op on the nil cache, so it can be called regardless of
+// WithShadowCache
+func (c *shadowCache) invalidate() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.doc = nil
+	c.generation++
+}
+
+// subscribeShadowCache subscribes for the accepted topics of the classic shadow operations and invalidates the cache on
+// every accepted update or delete. The subscription uses the wildcard topic, so it isn't affected by the shadow requests
+// subscribing for the same accepted topics temporarily
+func (t *Thing) subscribeShadowCache() error {
+	shadowTopic := t.shadowTopic("")
+
+	return t.subscribe(
+		shadowTopic+"/+/accepted",
+		t.shadowQoS(),
+		func(client mqtt.Client, msg mqtt.Message) {
+			if operation := strings.TrimPrefix(msg.Topic(), shadowTopic+"/"); !strings.HasPrefix(operation, "get/") {
+				t.shadowCache.invalidate()
+			}
+		},
+	)
+}
@@ -0,0 +1,32 @@
+package device
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestShadowCache(t *testing.T) {
+	c := &shadowCache{}
+
+	doc, generation := c.load()
+	assert.Nil(t, doc, "the empty cache returns no shadow")
+
+	c.store(Shadow(`{"version":1}`), generation)
+	doc, generation = c.load()
+	assert.Equal(t, Shadow(`{"version":1}`), doc, "the stored shadow is returned")
+
+	doc[0] = '['
+	doc, _ = c.load()
+	assert.Equal(t, Shadow(`{"version":1}`), doc, "the cached shadow isn't modified through the returned copy")
+
+	c.invalidate()
+	doc, _ = c.load()
+	assert.Nil(t, doc, "the invalidated cache returns no shadow")
+
+	c.store(Shadow(`{"version":2}`), generation)
+	doc, _ = c.load()
+	assert.Nil(t, doc, "the shadow requested before the invalidation isn't cached")
+
+	var disabled *shadowCache
+	assert.NotPanics(t, disabled.invalidate, "the nil cache invalidation is a no-op")
+}
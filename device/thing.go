@@ -1,21 +1,125 @@
 package device
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/url"
 	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eclipse/paho.mqtt.golang"
 )
 
-// Thing a structure for working with the AWS IoT device shadows
+// Thing a structure for working with the AWS IoT device shadows. Thing methods are safe for concurrent use by multiple
+// goroutines
 type Thing struct {
-	client    mqtt.Client
 	thingName ThingName
+	region    string
+	endpoint  string
+	opts      *options
+
+	// port is the port set explicitly in the endpoint, empty for the default one
+	port string
+
+	// connMu guards the client. It's held for reading during the subscription changes, so they don't interleave with
+	// the reconnection
+	connMu sync.RWMutex
+	client mqtt.Client
+
+	mu            sync.RWMutex
+	subscriptions map[string]*subscription
+	// handlerSeq numbers the subscription handlers, so they can be removed one by one
+	handlerSeq uint64
+
+	inflightMu sync.Mutex
+	inflight   map[mqtt.Token]struct{}
+
+	events chan ConnectionEvent
+	status connectionStatus
+
+	// inflightSlots limits the number of the in-flight publishes if configured with WithMaxInflightMessages
+	inflightSlots chan struct{}
+
+	// shadowCache is set if configured with WithShadowCache
+	shadowCache *shadowCache
+
+	// reconnectInProgress is set while Reconnect is running
+	reconnectInProgress int32
+
+	responses responseRouter
+
+	// ownClientTokens remembers the client tokens issued by the Thing to recognize the responses to its own requests
+	ownClientTokens clientTokenLog
+	// externalChanges is set once SubscribeForExternalShadowChanges is called, the updates are tagged only since then
+	externalChanges int32
+
+	// rest is the HTTP client of the AWS IoT HTTPS data plane requests
+	rest restClientCache
+}
+
+// subscription the MQTT subscription tracked by the Thing. The subscription is shared by all the handlers of its topic,
+// so adding a handler never replaces another one and the topic is unsubscribed only once its last handler is removed
+type subscription struct {
+	qos byte
+	// pending is set for the subscriptions made or lost while disconnected, they are sent to the broker once connected
+	pending bool
+
+	// handlersMu guards the handlers only, so the messages are dispatched without waiting for the registry lock
+	handlersMu sync.RWMutex
+	handlers   []subscriptionHandler
+}
+
+// subscriptionHandler the message handler of the subscription along with its registry id
+type subscriptionHandler struct {
+	id      uint64
+	handler mqtt.MessageHandler
+}
+
+// dispatch passes the message to all the handlers of the subscription
+func (s *subscription) dispatch(client mqtt.Client, msg mqtt.Message) {
+	s.handlersMu.RLock()
+	handlers := make([]mqtt.MessageHandler, len(s.handlers))
+	for i, h := range s.handlers {
+		handlers[i] = h.handler
+	}
+	s.handlersMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(client, msg)
+	}
+}
+
+// addHandler adds the handler with the id to the subscription
+func (s *subscription) addHandler(id uint64, handler mqtt.MessageHandler) {
+	s.handlersMu.Lock()
+	defer s.handlersMu.Unlock()
+	s.handlers = append(s.handlers, subscriptionHandler{id: id, handler: handler})
+}
+
+// removeHandler removes the handler with the id from the subscription and returns the number of the remaining ones
+func (s *subscription) removeHandler(id uint64) int {
+	s.handlersMu.Lock()
+	defer s.handlersMu.Unlock()
+
+	for i, h := range s.handlers {
+		if h.id == id {
+			s.handlers = append(s.handlers[:i:i], s.handlers[i+1:]...)
+			break
+		}
+	}
+	return len(s.handlers)
 }
 
 // ThingName the name of the AWS IoT device representation
@@ -39,143 +143,601 @@ func (s Shadow) String() string {
 // ShadowError represents the model for handling the errors occurred during updating the device shadow
 type ShadowError = Shadow
 
-// NewThing returns a new instance of Thing
-func NewThing(keyPair KeyPair, awsEndpoint string, thingName ThingName) (*Thing, error) {
-	tlsCert, err := tls.LoadX509KeyPair(keyPair.CertificatePath, keyPair.PrivateKeyPath)
+// NewThing returns a new instance of Thing configured with the provided options. The returned thing isn't connected,
+// the Connect method must be called to establish the MQTT session. The subscriptions made before connecting are
+// queued and sent to the broker once connected, no messages are delivered to their channels until then. The
+// subscriptions are restored on every reconnection, so the channels returned by the subscription methods live for the
+// life of the subscription rather than the connection and keep delivering the messages after the reconnects. The
+// endpoint may carry the scheme, path and port, the scheme and path are stripped while the host is used as is and the
+// port, if set, overrides the default one. Only the obviously wrong endpoints are rejected, e.g. the AWS one with an
+// invalid region
+func NewThing(keyPair KeyPair, awsEndpoint string, thingName ThingName, opts ...Option) (*Thing, error) {
+	if err := validateThingName(thingName); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := newTLSConfig(keyPair)
 	if err != nil {
-		return nil ,fmt.Errorf("failed to load the certificates: %v", err)
+		return nil, err
 	}
 
-	certs := x509.NewCertPool()
+	return newThing(awsEndpoint, thingName, tlsConfig, opts...)
+}
 
-	caPem, err := ioutil.ReadFile(keyPair.CACertificatePath)
+// NewThingWithCustomAuth returns a new instance of Thing authenticated with the MQTT username and password instead of
+// the device certificates, e.g. for the AWS IoT custom authorizers taking the token in the password. The connection is
+// still secured with TLS configured by the provided config, nil means the default TLS configuration. The returned
+// thing isn't connected, the Connect method must be called to establish the MQTT session
+func NewThingWithCustomAuth(awsEndpoint string, thingName ThingName, username, password string, tlsConfig *tls.Config, opts ...Option) (*Thing, error) {
+	if err := validateThingName(thingName); err != nil {
+		return nil, err
+	}
+
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+
+	return newThing(awsEndpoint, thingName, tlsConfig, append(opts, func(o *options) error {
+		o.username = username
+		o.password = password
+		return nil
+	})...)
+}
+
+// newThing applies the options and returns a new instance of Thing using the provided TLS configuration
+func newThing(awsEndpoint string, thingName ThingName, tlsConfig *tls.Config, opts ...Option) (*Thing, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, fmt.Errorf("invalid option: %w", err)
+		}
+	}
+	if len(o.connectUserProperties) > 0 {
+		return nil, fmt.Errorf("invalid option: %w", errUserProperties)
+	}
+
+	t, err := newThingInstance(awsEndpoint, thingName, o)
 	if err != nil {
 		return nil, err
 	}
+	t.client = mqtt.NewClient(t.newClientOptions(tlsConfig))
 
-	certs.AppendCertsFromPEM(caPem)
+	if o.persistentShadowSubscriptions {
+		if err := t.subscribePersistentShadowResponses(); err != nil {
+			return nil, err
+		}
+	}
 
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{tlsCert},
-		RootCAs:      certs,
+	if o.shadowCache {
+		t.shadowCache = &shadowCache{}
+		if err := t.subscribeShadowCache(); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// NewThingFromOptions returns a new instance of Thing wrapping the MQTT client created with the provided fully-built
+// client options and connected to the first configured broker, e.g. to reuse an elaborate MQTT client configuration the
+// Thing options don't cover. The provided options aren't modified, the connection handlers configured by them are
+// called after the Thing ones. The options must configure at least one broker. Note that RotateCredentials replaces the
+// client with the one configured by the Thing itself
+func NewThingFromOptions(opts *mqtt.ClientOptions, thingName ThingName) (*Thing, error) {
+	if err := validateThingName(thingName); err != nil {
+		return nil, err
+	}
+	if opts == nil || len(opts.Servers) == 0 {
+		return nil, errors.New("the MQTT client options must configure a broker")
+	}
+
+	o := defaultOptions()
+	// the reconnections are paced by the provided options only
+	o.initialReconnectInterval = 0
+
+	t, err := newThingInstance(opts.Servers[0].Host, thingName, o)
+	if err != nil {
+		return nil, err
 	}
 
+	clientOpts := *opts
+	t.setConnectionHandlers(&clientOpts)
+	t.client = mqtt.NewClient(&clientOpts)
+
+	if err := t.Connect(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// newThingInstance returns a new instance of Thing connecting to the endpoint with the applied options. The MQTT client
+// is left for the caller to create
+func newThingInstance(awsEndpoint string, thingName ThingName, o *options) (*Thing, error) {
+	endpoint, port, region, err := resolveEndpoint(awsEndpoint)
 	if err != nil {
 		return nil, err
 	}
 
-	awsServerURL := fmt.Sprintf("ssl://%s:8883", awsEndpoint)
+	t := &Thing{
+		thingName:     thingName,
+		region:        region,
+		endpoint:      endpoint,
+		port:          port,
+		opts:          o,
+		subscriptions: make(map[string]*subscription),
+		inflight:      make(map[mqtt.Token]struct{}),
+		events:        make(chan ConnectionEvent, connectionEventsBufferSize),
+	}
+	if o.maxInflight > 0 {
+		t.inflightSlots = make(chan struct{}, o.maxInflight)
+	}
+
+	return t, nil
+}
+
+// NewThingAndConnect returns a new instance of Thing configured with the provided options and connected to the AWS
+// IoT endpoint
+func NewThingAndConnect(keyPair KeyPair, awsEndpoint string, thingName ThingName, opts ...Option) (*Thing, error) {
+	t, err := NewThing(keyPair, awsEndpoint, thingName, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.Connect(context.Background()); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Connect establishes the MQTT session with the AWS IoT endpoint. The context limits the time of waiting for the
+// connection, though the connection attempt itself is bounded by the MQTT connect timeout
+func (t *Thing) Connect(ctx context.Context) error {
+	if err := waitToken(ctx, t.currentClient().Connect()); err != nil {
+		if t.opts.sigV4 != nil {
+			if presignErr := t.opts.sigV4.lastError(); presignErr != nil {
+				err = presignErr
+			}
+		}
+		return fmt.Errorf("failed to connect to %s: %w", t.endpoint, err)
+	}
+	return nil
+}
+
+// waitToken waits for the MQTT token to complete until the context is done. Returns the token error or the context
+// error
+func waitToken(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newClientOptions returns the MQTT client options for the connection to the AWS IoT endpoint
+func (t *Thing) newClientOptions(tlsConfig *tls.Config) *mqtt.ClientOptions {
+	o := t.opts
+	scheme, port, path := "ssl", "8883", ""
+	if o.sigV4 != nil {
+		scheme, port, path = "wss", "443", "/mqtt"
+	}
+	if t.port != "" {
+		port = t.port
+	}
+	awsServerURL := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(t.endpoint, port), path)
 
 	mqttOpts := mqtt.NewClientOptions()
 	mqttOpts.AddBroker(awsServerURL)
-	mqttOpts.SetMaxReconnectInterval(1 * time.Second)
-	mqttOpts.SetClientID(string(thingName))
+	mqttOpts.SetMaxReconnectInterval(o.maxReconnectInterval)
+	if o.reconnectBackoff != nil {
+		// the delays are taken from the backoff, so the MQTT client's own one is reduced to its minimum
+		mqttOpts.SetMaxReconnectInterval(time.Millisecond)
+	}
+	mqttOpts.SetAutoReconnect(o.autoReconnect)
+	mqttOpts.SetOrderMatters(o.orderedDelivery)
+	if o.keepAlive > 0 {
+		mqttOpts.SetKeepAlive(o.keepAlive)
+	}
+	if o.pingTimeout > 0 {
+		mqttOpts.SetPingTimeout(o.pingTimeout)
+	}
+	if o.writeTimeout > 0 {
+		mqttOpts.SetWriteTimeout(o.writeTimeout)
+	}
+	if o.username != "" {
+		mqttOpts.SetUsername(o.username)
+		mqttOpts.SetPassword(o.password)
+	}
+	if o.maxInflight > 0 {
+		mqttOpts.SetMaxResumePubInFlight(o.maxInflight)
+	}
+	mqttOpts.SetConnectRetry(o.connectRetry)
+	if o.connectRetryInterval > 0 {
+		mqttOpts.SetConnectRetryInterval(o.connectRetryInterval)
+	}
+	mqttOpts.SetClientID(string(t.thingName))
+	if o.tlsSessionCacheSize > 0 {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(o.tlsSessionCacheSize)
+	}
+	if o.keyLogWriter != nil {
+		tlsConfig.KeyLogWriter = o.keyLogWriter
+	}
 	mqttOpts.SetTLSConfig(tlsConfig)
+	if o.will != nil {
+		mqttOpts.SetBinaryWill(o.will.topic, o.will.payload, o.will.qos, o.will.retained)
+	}
+	if o.proxyURL != nil {
+		mqttOpts.SetCustomOpenConnectionFn(newProxyConnectionFn(o.proxyURL, o.tlsHandshakeTimeout))
+	} else if o.sigV4 == nil {
+		mqttOpts.SetCustomOpenConnectionFn(newTLSConnectionFn(o.tlsHandshakeTimeout))
+	}
+	if o.sigV4 != nil {
+		// the handler is called before every connection attempt, including the reconnections, so the presigned URL is
+		// always fresh
+		mqttOpts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+			// the attempt goes on with the previous URL, while the error is recorded, so it's reported by Connect and
+			// ConnectionStatus instead of the rejected handshake
+			if err := o.sigV4.presign(broker, o.clock.Now()); err != nil {
+				t.recordConnectionLoss(err)
+			}
+			return tlsCfg
+		})
+	}
 
-	c := mqtt.NewClient(mqttOpts)
-	if token := c.Connect(); token.Wait() && token.Error() != nil {
-		return nil, token.Error()
+	t.setConnectionHandlers(mqttOpts)
+
+	return mqttOpts
+}
+
+// setConnectionHandlers sets the MQTT client connection handlers tracking the connection state of the Thing. The
+// handlers already set in the client options are called after the Thing ones
+func (t *Thing) setConnectionHandlers(mqttOpts *mqtt.ClientOptions) {
+	o := t.opts
+	onConnect, onConnectionLost, onReconnecting := mqttOpts.OnConnect, mqttOpts.OnConnectionLost, mqttOpts.OnReconnecting
+
+	// the handler is called on every connection, so all the calls after the first one are reconnections
+	var connections, reconnecting, attempts int32
+	mqttOpts.SetOnConnectHandler(func(c mqtt.Client) {
+		atomic.StoreInt32(&reconnecting, 0)
+		atomic.StoreInt32(&attempts, 0)
+		reconnection := atomic.AddInt32(&connections, 1) > 1
+		if reconnection {
+			o.metrics.IncReconnect()
+		}
+		// the shadow changes made while disconnected are missed, so the cached shadow can't be trusted anymore
+		t.shadowCache.invalidate()
+		if reconnection && o.resubscribeJitter > 0 {
+			// the devices of the fleet reconnect at once after the outage, so their resubscriptions are spread in time
+			time.Sleep(time.Duration(rand.Int63n(int64(o.resubscribeJitter))))
+		}
+		t.flushPendingSubscriptions(c)
+		// the event is emitted once the subscriptions are restored, so the messages published in reaction to it get
+		// their responses
+		t.emitConnectionEvent(Connected, nil)
+		if onConnect != nil {
+			onConnect(c)
+		}
+	})
+	mqttOpts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		t.markSubscriptionsPending()
+		t.shadowCache.invalidate()
+		t.recordConnectionLoss(err)
+		t.emitConnectionEvent(Disconnected, err)
+		if onConnectionLost != nil {
+			onConnectionLost(c, err)
+		}
+	})
+
+	// the handler is called before every reconnection attempt. Unless the backoff is configured, only the first one after
+	// the connection loss is delayed as the following ones are delayed by the MQTT client backoff
+	mqttOpts.SetReconnectingHandler(func(c mqtt.Client, opts *mqtt.ClientOptions) {
+		if onReconnecting != nil {
+			defer onReconnecting(c, opts)
+		}
+		if o.reconnectBackoff != nil {
+			time.Sleep(o.reconnectBackoff(int(atomic.AddInt32(&attempts, 1))))
+			return
+		}
+		if atomic.CompareAndSwapInt32(&reconnecting, 0, 1) {
+			time.Sleep(o.initialReconnectInterval)
+		}
+	})
+}
+
+// newTLSConfig loads the device certificates and returns the TLS configuration for the MQTT connection
+func newTLSConfig(keyPair KeyPair) (*tls.Config, error) {
+	tlsCert, err := tls.LoadX509KeyPair(keyPair.CertificatePath, keyPair.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the certificates: %w", err)
+	}
+
+	certs := x509.NewCertPool()
+
+	caPem, err := ioutil.ReadFile(keyPair.CACertificatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the CA certificate: %w", err)
 	}
 
-	return &Thing{
-		client:    c,
-		thingName: thingName,
+	if !certs.AppendCertsFromPEM(caPem) {
+		return nil, errors.New("no valid CA certificates found in CACertificatePath")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		RootCAs:      certs,
 	}, nil
 }
 
-// Disconnect terminates the MQTT connection between the client and the AWS server. Recommended to use in defer to avoid
-// connection leaks.
-func (t *Thing) Disconnect() {
+// RotateCredentials reconnects the thing using the new device certificates and restores all the subscriptions
+// tracked by the Thing. In case the new connection fails the previous one is restored and the error is returned
+func (t *Thing) RotateCredentials(newKeyPair KeyPair) error {
+	tlsConfig, err := newTLSConfig(newKeyPair)
+	if err != nil {
+		return err
+	}
+
+	t.connMu.Lock()
+	defer t.connMu.Unlock()
+
+	// AWS IoT drops the older connection with the same client ID, so the current one is closed first
 	t.client.Disconnect(1)
+
+	c := mqtt.NewClient(t.newClientOptions(tlsConfig))
+	if token := c.Connect(); token.Wait() && token.Error() != nil {
+		if restoreToken := t.client.Connect(); restoreToken.Wait() && restoreToken.Error() != nil {
+			return fmt.Errorf("failed to connect with the new credentials: %w; failed to restore the connection: %v", token.Error(), restoreToken.Error())
+		}
+		if err := t.resubscribe(t.client); err != nil {
+			return fmt.Errorf("failed to connect with the new credentials: %w; %v", token.Error(), err)
+		}
+		return fmt.Errorf("failed to connect with the new credentials: %w", token.Error())
+	}
+
+	t.client = c
+	return t.resubscribe(c)
 }
 
-// GetThingShadow returns the current thing shadow
-func (t *Thing) GetThingShadow() (Shadow, error) {
-	shadowChan := make(chan Shadow)
-	errChan := make(chan error)
+// ErrReconnectInProgress is returned by Reconnect if another reconnect hasn't completed yet
+var ErrReconnectInProgress = errors.New("reconnect already in progress")
 
-	defer t.unsubscribe(
-		fmt.Sprintf("$aws/things/%s/shadow/get/accepted", t.thingName),
-		fmt.Sprintf("$aws/things/%s/shadow/get/rejected", t.thingName),
-	)
+// Reconnect drops the MQTT connection and establishes a new one with the same credentials, e.g. to apply the policy
+// changed on the server side, and restores all the subscriptions tracked by the Thing. Returns ErrReconnectInProgress
+// if called while another reconnect is running
+func (t *Thing) Reconnect() error {
+	if !atomic.CompareAndSwapInt32(&t.reconnectInProgress, 0, 1) {
+		return ErrReconnectInProgress
+	}
+	defer atomic.StoreInt32(&t.reconnectInProgress, 0)
 
-	if token := t.client.Subscribe(
-		fmt.Sprintf("$aws/things/%s/shadow/get/accepted", t.thingName),
-		0,
-		func(client mqtt.Client, msg mqtt.Message) {
-			shadowChan <- msg.Payload()
-		},
-	); token.Wait() && token.Error() != nil {
-		return nil, token.Error()
+	t.connMu.Lock()
+	defer t.connMu.Unlock()
+
+	t.client.Disconnect(1)
+	t.emitConnectionEvent(Disconnected, nil)
+
+	if token := t.client.Connect(); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to reconnect to %s: %w", t.endpoint, token.Error())
 	}
+	return t.resubscribe(t.client)
+}
 
-	if token := t.client.Subscribe(
-		fmt.Sprintf("$aws/things/%s/shadow/get/rejected", t.thingName),
-		0,
-		func(client mqtt.Client, msg mqtt.Message) {
-			errChan <- errors.New(string(msg.Payload()))
-		},
-	); token.Wait() && token.Error() != nil {
-		return nil, token.Error()
+// resubscribe restores all the subscriptions tracked by the Thing using the provided client
+func (t *Thing) resubscribe(c mqtt.Client) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for topic, sub := range t.subscriptions {
+		if err := waitSubscription(c.Subscribe(topic, sub.qos, sub.dispatch), topic); err != nil {
+			return fmt.Errorf("failed to restore the subscription to %s: %w", topic, err)
+		}
+		sub.pending = false
 	}
+	return nil
+}
 
-	if token := t.client.Publish(
-		fmt.Sprintf("$aws/things/%s/shadow/get", t.thingName),
-		0,
-		false,
-		[]byte("{}"),
-	); token.Wait() && token.Error() != nil {
-		return nil, token.Error()
+// markSubscriptionsPending marks all the tracked subscriptions as pending, so they are restored with the same handlers
+// once connected again. The broker doesn't persist the subscriptions of the clean MQTT session across the connections
+func (t *Thing) markSubscriptionsPending() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, sub := range t.subscriptions {
+		sub.pending = true
+	}
+}
+
+// flushPendingSubscriptions sends the subscriptions queued while disconnected using the provided client. The failed
+// ones stay pending until the next connection
+func (t *Thing) flushPendingSubscriptions(c mqtt.Client) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for topic, sub := range t.subscriptions {
+		if !sub.pending {
+			continue
+		}
+		if err := waitSubscription(c.Subscribe(topic, sub.qos, sub.dispatch), topic); err != nil {
+			t.opts.metrics.IncSubscribeError(topic)
+			continue
+		}
+		sub.pending = false
 	}
+}
+
+// Client returns the underlying MQTT client as an escape hatch for the features the SDK doesn't wrap. Note that the
+// subscriptions made with the client directly bypass the SDK subscription tracking, so they aren't restored by
+// RotateCredentials. The client is replaced by RotateCredentials, so it shouldn't be stored for a long time
+func (t *Thing) Client() mqtt.Client {
+	return t.currentClient()
+}
+
+// currentClient returns the MQTT client the thing is connected with
+func (t *Thing) currentClient() mqtt.Client {
+	t.connMu.RLock()
+	defer t.connMu.RUnlock()
+	return t.client
+}
+
+// thingTopic returns the topic of the thing built from the configured topic prefix, the thing name and the provided
+// topic levels
+func (t *Thing) thingTopic(levels ...string) string {
+	return t.topicFor(t.thingName, levels...)
+}
+
+// topicFor acts like thingTopic but builds the topic of the thing with the provided name
+func (t *Thing) topicFor(thingName ThingName, levels ...string) string {
+	return buildTopic(t.opts.topicPrefix, thingName, levels...)
+}
 
-	for {
+// Region returns the AWS region parsed from the endpoint the thing is connected to. Returns an empty string if the
+// endpoint doesn't contain the region, e.g. in case of a custom domain
+func (t *Thing) Region() string {
+	return t.region
+}
+
+// Disconnect terminates the MQTT connection between the client and the AWS server. Recommended to use in defer to avoid
+// connection leaks.
+func (t *Thing) Disconnect() {
+	t.currentClient().Disconnect(1)
+	t.markSubscriptionsPending()
+	t.shadowCache.invalidate()
+	t.emitConnectionEvent(Disconnected, nil)
+}
+
+// DrainAndDisconnect waits up to the timeout for all the in-flight publishes to be delivered and terminates the MQTT
+// connection afterwards. Returns an error if any of the publishes is still pending when the timeout expires, the
+// connection is terminated anyway
+func (t *Thing) DrainAndDisconnect(timeout time.Duration) error {
+	deadline := t.opts.clock.Now().Add(timeout)
+
+	t.inflightMu.Lock()
+	tokens := make([]mqtt.Token, 0, len(t.inflight))
+	for token := range t.inflight {
+		tokens = append(tokens, token)
+	}
+	t.inflightMu.Unlock()
+
+	pending := 0
+	for _, token := range tokens {
 		select {
-		case s, ok := <-shadowChan:
-			if !ok {
-				return nil, errors.New("failed to read from shadow channel")
-			}
-			return s, nil
-		case err, ok := <-errChan:
-			if !ok {
-				return nil, errors.New("failed to read from error channel")
-			}
-			return nil, err
+		case <-token.Done():
+		case <-t.opts.clock.After(deadline.Sub(t.opts.clock.Now())):
+			pending++
 		}
 	}
+
+	t.Disconnect()
+
+	if pending > 0 {
+		return fmt.Errorf("disconnected with %d publishes still pending", pending)
+	}
+	return nil
+}
+
+// GetThingShadow returns the current thing shadow. In case the thing has no shadow yet the returned error matches
+// ErrNoShadow. If configured with WithShadowCache the shadow is returned from the cache until it changes
+func (t *Thing) GetThingShadow() (Shadow, error) {
+	if t.shadowCache == nil {
+		return t.GetThingShadowWithRequest(ShadowGetRequest{})
+	}
+
+	cached, generation := t.shadowCache.load()
+	if cached != nil {
+		return cached, nil
+	}
+
+	s, err := t.GetThingShadowWithRequest(ShadowGetRequest{})
+	if err != nil {
+		return nil, err
+	}
+	t.shadowCache.store(s, generation)
+	return s, nil
 }
 
-// UpdateThingShadow publishes an async message with new thing shadow
+// GetThingShadowReader acts like GetThingShadow but returns the shadow document as a reader, e.g. to be parsed with
+// json.Decoder. The whole document is received before the method returns, the reader doesn't stream it and saves no
+// memory compared to GetThingShadow
+func (t *Thing) GetThingShadowReader() (io.ReadCloser, error) {
+	s, err := t.GetThingShadow()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(s)), nil
+}
+
+// GetThingShadowWithRequest returns the current thing shadow requested with the provided request body. If the request
+// carries the client token only the response with the same client token is accepted
+func (t *Thing) GetThingShadowWithRequest(req ShadowGetRequest) (Shadow, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the shadow get request: %w", err)
+	}
+
+	return t.shadowRequest(context.Background(), t.shadowTopic(""), "get", req.ClientToken, payload)
+}
+
+// UpdateThingShadow publishes an async message with new thing shadow. AWS IoT merges the update into the existing shadow
+// state: the provided fields are added or replaced, the omitted ones are left untouched and the ones set to null are
+// deleted. Once SubscribeForExternalShadowChanges is called, the update is published with the generated client token
+// unless it carries one, so its accepted response is recognized as the Thing own one
 func (t *Thing) UpdateThingShadow(payload Shadow) error {
-	token := t.client.Publish(fmt.Sprintf("$aws/things/%s/shadow/update", t.thingName), 0, false, []byte(payload))
-	token.Wait()
-	return token.Error()
+	payload, err := t.withOwnClientToken(payload)
+	if err != nil {
+		return err
+	}
+	if err := t.validateShadow(payload); err != nil {
+		return err
+	}
+	return t.publish(t.shadowTopic("")+"/update", t.shadowQoS(), false, []byte(payload))
+}
+
+// validateShadow checks the shadow update fits the shadow size limit and validates it if the validation is enabled with
+// WithValidation
+func (t *Thing) validateShadow(payload Shadow) error {
+	return validateShadowWith(t.opts, payload)
+}
+
+// validateShadowWith implements validateShadow for the options shared by the Thing and the HTTPThing
+func validateShadowWith(o *options, payload Shadow) error {
+	if err := validatePayloadSize("shadow", len(payload), o.maxShadowSize); err != nil {
+		return err
+	}
+	if !o.validateShadows {
+		return nil
+	}
+	return validateShadowDocument(payload)
 }
 
 // SubscribeForThingShadowChanges subscribes for the device shadow update topic and returns two channels: shadow and shadow error.
 // The shadow channel will handle all accepted device shadow updates. The shadow error channel will handle all rejected device
 // shadow updates
 func (t *Thing) SubscribeForThingShadowChanges() (chan Shadow, chan ShadowError, error) {
-	shadowChan := make(chan Shadow)
-	shadowErrChan := make(chan ShadowError)
+	shadowChan := make(chan Shadow, t.opts.subscriptionBufferSize)
+	shadowErrChan := make(chan ShadowError, t.opts.subscriptionBufferSize)
 
-	if token := t.client.Subscribe(
-		fmt.Sprintf("$aws/things/%s/shadow/update/accepted", t.thingName),
-		0,
+	if err := t.subscribe(
+		t.shadowTopic("")+"/update/accepted",
+		t.shadowQoS(),
 		func(client mqtt.Client, msg mqtt.Message) {
 			shadowChan <- msg.Payload()
 		},
-	); token.Wait() && token.Error() != nil {
-		return nil, nil, token.Error()
+	); err != nil {
+		return nil, nil, err
 	}
 
-	if token := t.client.Subscribe(
-		fmt.Sprintf("$aws/things/%s/shadow/update/rejected", t.thingName),
-		0,
+	if err := t.subscribe(
+		t.shadowTopic("")+"/update/rejected",
+		t.shadowQoS(),
 		func(client mqtt.Client, msg mqtt.Message) {
+			t.opts.metrics.IncShadowRejection()
 			shadowErrChan <- msg.Payload()
 		},
-	); token.Wait() && token.Error() != nil {
-		return nil, nil, token.Error()
+	); err != nil {
+		return nil, nil, err
 	}
 
 	return shadowChan, shadowErrChan, nil
@@ -183,107 +745,548 @@ func (t *Thing) SubscribeForThingShadowChanges() (chan Shadow, chan ShadowError,
 
 // UpdateThingShadowDocument publishes an async message with new thing shadow document
 func (t *Thing) UpdateThingShadowDocument(payload Shadow) error {
-	token := t.client.Publish(fmt.Sprintf("$aws/things/%s/shadow/update/documents", t.thingName), 0, false, []byte(payload))
-	token.Wait()
-	return token.Error()
+	return t.publish(t.shadowTopic("")+"/update/documents", t.shadowQoS(), false, []byte(payload))
 }
 
 // DeleteThingShadow publishes a message to remove the device's shadow and waits for the result. In case shadow delete was
-// rejected the method will return error
+// rejected the method will return the ShadowRejection error
 func (t *Thing) DeleteThingShadow() error {
-	shadowChan := make(chan Shadow)
-	errChan := make(chan error)
+	_, err := t.shadowRequest(context.Background(), t.shadowTopic(""), "delete", "", []byte("{}"))
+	return err
+}
+
+// DeleteThingShadowWithTimeout acts like DeleteThingShadow but stops waiting for the result after the timeout. In case
+// shadow delete was rejected the method will return the ShadowRejection error, which reports NotFound if there was no
+// shadow to delete
+func (t *Thing) DeleteThingShadowWithTimeout(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	_, err := t.shadowRequest(ctx, t.shadowTopic(""), "delete", "", []byte("{}"))
+	return err
+}
+
+// DeleteThingShadowWithResult acts like DeleteThingShadow but returns the version of the deleted shadow reported by AWS
+// IoT in the accepted response, e.g. to log or verify the deletion
+func (t *Thing) DeleteThingShadowWithResult() (version int, err error) {
+	s, err := t.shadowRequest(context.Background(), t.shadowTopic(""), "delete", "", []byte("{}"))
+	if err != nil {
+		return 0, err
+	}
+
+	response := struct {
+		Version int `json:"version"`
+	}{}
+	if err := json.Unmarshal(s, &response); err != nil {
+		return 0, fmt.Errorf("failed to parse the shadow delete response: %w", err)
+	}
+	return response.Version, nil
+}
+
+// PublishToCustomTopic publishes an async message to the custom topic.
+// The specified topic argument will be prepended by a prefix "$aws/things/<thing_name>"
+func (t *Thing) PublishToCustomTopic(payload Shadow, topic string) error {
+	return t.publishToCustomTopic(topic, payload)
+}
+
+// publishToCustomTopic implements PublishToCustomTopic for the payload of any type
+func (t *Thing) publishToCustomTopic(topic string, payload []byte) error {
+	fullTopic := t.thingTopic(topic)
+	if err := validateTopic(fullTopic, false); err != nil {
+		return err
+	}
+
+	encoded, err := t.encodePayload(payload)
+	if err != nil {
+		return err
+	}
 
-	defer t.unsubscribe(
-		fmt.Sprintf("$aws/things/%s/shadow/delete/accepted", t.thingName),
-		fmt.Sprintf("$aws/things/%s/shadow/delete/rejected", t.thingName),
+	return t.publish(
+		fullTopic,
+		t.opts.defaultQoS,
+		false,
+		encoded,
 	)
+}
 
-	if token := t.client.Subscribe(
-		fmt.Sprintf("$aws/things/%s/shadow/delete/accepted", t.thingName),
-		0,
+// PublishToCustomTopicConfirmed publishes the message to the custom topic with QoS 1 and waits for the broker to
+// acknowledge it until the context is done. The specified topic argument will be prepended by a prefix
+// "$aws/things/<thing_name>"
+func (t *Thing) PublishToCustomTopicConfirmed(ctx context.Context, payload Shadow, topic string) error {
+	fullTopic := t.thingTopic(topic)
+	if err := validateTopic(fullTopic, false); err != nil {
+		return err
+	}
+
+	encoded, err := t.encodePayload(payload)
+	if err != nil {
+		return err
+	}
+
+	return t.publishContext(ctx, fullTopic, 1, false, encoded)
+}
+
+// PublishToBasicIngest publishes the message directly to the topic rule using the basic ingest topic
+// "$aws/rules/<rule_name>/<sub_topic>", which bypasses the message broker and its messaging costs. The basic ingest
+// topics are publish-only, nobody can subscribe to them. The sub topic is optional and is available to the rule
+// through the topic function
+func (t *Thing) PublishToBasicIngest(ruleName, subTopic string, payload []byte, qos byte) error {
+	if err := validateRuleName(ruleName); err != nil {
+		return err
+	}
+
+	topic := path.Join("$aws/rules", ruleName, subTopic)
+	if err := validateTopic(topic, false); err != nil {
+		return err
+	}
+
+	return t.publish(topic, qos, false, payload)
+}
+
+// PublishAsync publishes the payload to the topic without waiting for the delivery and returns the delivery token, so
+// the caller decides when to wait for it. Unlike the custom topic methods, the topic is used as is without any prefix.
+// The publish is tracked until completed, so DrainAndDisconnect waits for it
+func (t *Thing) PublishAsync(topic string, payload []byte, qos byte) (mqtt.Token, error) {
+	if err := validateTopic(topic, false); err != nil {
+		return nil, err
+	}
+
+	start := t.opts.clock.Now()
+	token, err := t.startPublish(context.Background(), topic, qos, false, payload)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-token.Done()
+		t.observePublish(topic, start, token.Error())
+	}()
+
+	return token, nil
+}
+
+// PublishRequest describes a single message of the batch publish
+type PublishRequest struct {
+	// Topic is the custom topic which will be prepended by a prefix "$aws/things/<thing_name>"
+	Topic    string
+	Payload  Shadow
+	QoS      byte
+	Retained bool
+}
+
+// PublishBatchError aggregates the errors occurred during the batch publish
+type PublishBatchError []error
+
+// Error joins all the aggregated errors into a single message
+func (e PublishBatchError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// PublishBatch publishes all the messages to the custom topics without waiting for each of them separately and only then
+// waits for all the delivery tokens, so the total latency is close to a single round trip instead of one per message.
+// The topic of each message will be prepended by a prefix "$aws/things/<thing_name>". In case any of the publishes
+// fails the method returns a PublishBatchError containing all the occurred errors
+func (t *Thing) PublishBatch(messages []PublishRequest) error {
+	topics := make([]string, len(messages))
+	for i, m := range messages {
+		topics[i] = t.thingTopic(m.Topic)
+		if err := validateTopic(topics[i], false); err != nil {
+			return err
+		}
+	}
+
+	start := t.opts.clock.Now()
+	tokens := make([]mqtt.Token, len(messages))
+	for i, m := range messages {
+		token, err := t.startPublish(context.Background(), topics[i], m.QoS, m.Retained, []byte(m.Payload))
+		if err != nil {
+			return err
+		}
+		tokens[i] = token
+	}
+
+	var errs PublishBatchError
+	for i, token := range tokens {
+		err := t.waitPublish(context.Background(), start, token)
+		t.observePublish(topics[i], start, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to publish to %s: %w", topics[i], err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// SubscribeForCustomTopic subscribes for the custom topic and returns the channel with the topic messages along with
+// the cancel function, which closes the channel and unsubscribes from the topic unless it's still subscribed by others.
+// The messages arriving after the cancel are dropped. The specified topic argument will be prepended by a prefix
+// "$aws/things/<thing_name>". The messages which fail to be decoded by the codec configured with WithPayloadCodec are
+// skipped
+func (t *Thing) SubscribeForCustomTopic(topic string) (chan Shadow, func() error, error) {
+	return subscribeCustomTopic[Shadow](t, topic)
+}
+
+// subscribeCustomTopic implements SubscribeForCustomTopic for any payload type
+func subscribeCustomTopic[T ~[]byte](t *Thing, topic string) (chan T, func() error, error) {
+	fullTopic := t.thingTopic(topic)
+	if err := validateTopic(fullTopic, true); err != nil {
+		return nil, nil, err
+	}
+
+	shadowChan := make(chan T, t.opts.subscriptionBufferSize)
+
+	// closeMu is held for reading while sending to the channel, so it's never closed in the middle of a send, and done
+	// releases the sends blocked by the absent reader
+	var closeMu sync.RWMutex
+	closed := false
+	done := make(chan struct{})
+
+	remove, err := t.addHandler(
+		fullTopic,
+		t.opts.defaultQoS,
 		func(client mqtt.Client, msg mqtt.Message) {
-			shadowChan <- msg.Payload()
+			payload, err := t.decodePayload(msg.Payload())
+			if err != nil {
+				return
+			}
+
+			closeMu.RLock()
+			defer closeMu.RUnlock()
+			if closed {
+				return
+			}
+			select {
+			case shadowChan <- T(payload):
+			case <-done:
+			}
 		},
-	); token.Wait() && token.Error() != nil {
-		return token.Error()
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	var unsubscribeErr error
+	cancel := func() error {
+		once.Do(func() {
+			// the blocked sends are released first, so they don't stall the MQTT client awaiting the unsubscription
+			close(done)
+			closeMu.Lock()
+			closed = true
+			close(shadowChan)
+			closeMu.Unlock()
+
+			unsubscribeErr = remove()
+		})
+		return unsubscribeErr
+	}
+
+	return shadowChan, cancel, nil
+}
+
+// OnCustomTopic subscribes for the custom topic and calls the handler with every topic message. Unlike
+// SubscribeForCustomTopic no channel is involved, the handler is called directly by the MQTT client message loop, so it
+// must return quickly and must not block, otherwise the delivery of all the other messages stalls. The specified topic
+// argument will be prepended by a prefix "$aws/things/<thing_name>"
+func (t *Thing) OnCustomTopic(topic string, handler func(payload []byte)) error {
+	fullTopic := t.thingTopic(topic)
+	if err := validateTopic(fullTopic, true); err != nil {
+		return err
 	}
 
-	if token := t.client.Subscribe(
-		fmt.Sprintf("$aws/things/%s/shadow/delete/rejected", t.thingName),
-		0,
+	return t.subscribe(
+		fullTopic,
+		t.opts.defaultQoS,
 		func(client mqtt.Client, msg mqtt.Message) {
-			errChan <- errors.New(string(msg.Payload()))
+			payload, err := t.decodePayload(msg.Payload())
+			if err != nil {
+				return
+			}
+			handler(payload)
 		},
-	); token.Wait() && token.Error() != nil {
-		return token.Error()
-	}
+	)
+}
 
-	if token := t.client.Publish(
-		fmt.Sprintf("$aws/things/%s/shadow/delete", t.thingName),
-		0,
-		false,
-		[]byte("{}"),
-	); token.Wait() && token.Error() != nil {
-		return token.Error()
+// SubscribeOnce subscribes for the custom topic, waits for a single message until the context is done and unsubscribes
+// on return, e.g. to await the reply to the request published to another topic. The subscription is shared with the
+// other subscribers of the topic, so they keep receiving the messages after the return. The specified topic argument
+// will be prepended by a prefix "$aws/things/<thing_name>"
+func (t *Thing) SubscribeOnce(ctx context.Context, topic string) ([]byte, error) {
+	fullTopic := t.thingTopic(topic)
+	if err := validateTopic(fullTopic, true); err != nil {
+		return nil, err
 	}
 
-	for {
-		select {
-		case _, ok := <-shadowChan:
-			if !ok {
-				return errors.New("failed to read from shadow channel")
+	// the channel is buffered and written without blocking, so the following messages never stall the MQTT client
+	payloadChan := make(chan []byte, 1)
+
+	remove, err := t.addHandler(
+		fullTopic,
+		t.opts.defaultQoS,
+		func(client mqtt.Client, msg mqtt.Message) {
+			payload, err := t.decodePayload(msg.Payload())
+			if err != nil {
+				return
 			}
-			return nil
-		case err, ok := <-errChan:
-			if !ok {
-				return errors.New("failed to read from error channel")
+			select {
+			case payloadChan <- payload:
+			default:
 			}
-			return err
-		}
+		},
+	)
+	if err != nil {
+		return nil, err
 	}
-}
+	defer remove()
 
-// PublishToCustomTopic publishes an async message to the custom topic.
-// The specified topic argument will be prepended by a prefix "$aws/things/<thing_name>"
-func (t *Thing) PublishToCustomTopic(payload Shadow, topic string) error {
-	token := t.client.Publish(
-		path.Join("$aws/things", t.thingName, topic),
-		0,
-		false,
-		[]byte(payload),
-	)
-	token.Wait()
-	return token.Error()
+	select {
+	case payload := <-payloadChan:
+		return payload, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("failed to wait for the %s message: %w", fullTopic, ctx.Err())
+	}
 }
 
-// SubscribeForCustomTopic subscribes for the custom topic and returns the channel with the topic messages.
-// The specified topic argument will be prepended by a prefix "$aws/things/<thing_name>"
-func (t *Thing) SubscribeForCustomTopic(topic string) (chan Shadow, error) {
-	shadowChan := make(chan Shadow)
+// SubscribeRaw subscribes for the topic with the provided QoS and returns the channel with the received MQTT messages,
+// which expose the message metadata like the message ID, QoS, retained and duplicate flags. Unlike the custom topic
+// methods, the topic is used as is without any prefix. The subscription can be terminated with Unsubscribe
+func (t *Thing) SubscribeRaw(topic string, qos byte) (<-chan mqtt.Message, error) {
+	if err := validateTopic(topic, true); err != nil {
+		return nil, err
+	}
 
-	if token := t.client.Subscribe(
-		path.Join("$aws/things", t.thingName, topic),
-		0,
+	msgChan := make(chan mqtt.Message, t.opts.subscriptionBufferSize)
+
+	if err := t.subscribe(
+		topic,
+		qos,
 		func(client mqtt.Client, msg mqtt.Message) {
-			shadowChan <- msg.Payload()
+			msgChan <- msg
 		},
-	); token.Wait() && token.Error() != nil {
-		return nil, token.Error()
+	); err != nil {
+		return nil, err
 	}
 
-	return shadowChan, nil
+	return msgChan, nil
+}
+
+// Unsubscribe terminates the subscription to the topic made with SubscribeRaw. The topic is used as is without any
+// prefix
+func (t *Thing) Unsubscribe(topic string) error {
+	return t.unsubscribe(topic)
 }
 
 // UnsubscribeFromCustomTopic terminates the subscription to the custom topic.
 // The specified topic argument will be prepended by a prefix "$aws/things/<thing_name>"
-func (t Thing) UnsubscribeFromCustomTopic(topic string) error {
-	return t.unsubscribe(path.Join("$aws/things", t.thingName, topic))
+func (t *Thing) UnsubscribeFromCustomTopic(topic string) error {
+	return t.unsubscribe(t.thingTopic(topic))
+}
+
+// publish publishes the payload to the topic and waits for the delivery token
+func (t *Thing) publish(topic string, qos byte, retained bool, payload []byte) error {
+	return t.publishContext(context.Background(), topic, qos, retained, payload)
+}
+
+// publishContext publishes the payload to the topic and waits for the delivery token until the context is done
+func (t *Thing) publishContext(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error {
+	start := t.opts.clock.Now()
+	token, err := t.startPublish(ctx, topic, qos, retained, payload)
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+
+	err = t.waitPublish(ctx, start, token)
+	t.observePublish(topic, start, err)
+	if err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	return nil
 }
 
-// unsubscribe terminates the MQTT subscription for the provided tokens
-func (t Thing) unsubscribe(topics ...string) error {
-	token := t.client.Unsubscribe(topics...)
-	token.Wait()
-	return token.Error()
+// ErrWriteTimeout is returned when the publish isn't completed within the timeout configured with WithWriteTimeout
+var ErrWriteTimeout = errors.New("the publish write timed out")
+
+// waitPublish waits for the publish token until the context is done or the write timeout configured with
+// WithWriteTimeout elapses since the start of the publish
+func (t *Thing) waitPublish(ctx context.Context, start time.Time, token mqtt.Token) error {
+	if t.opts.writeTimeout <= 0 {
+		return waitToken(ctx, token)
+	}
+
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.opts.clock.After(start.Add(t.opts.writeTimeout).Sub(t.opts.clock.Now())):
+		return ErrWriteTimeout
+	}
+}
+
+// ErrNotConnected is returned when publishing while the thing is disconnected if the offline queueing is disabled with
+// WithOfflineQueueing
+var ErrNotConnected = errors.New("the thing is not connected")
+
+// startPublish publishes the payload and returns the delivery token without waiting for it. The token is tracked as the
+// in-flight one, so DrainAndDisconnect can wait for it, until completed. In case the in-flight window configured with
+// WithMaxInflightMessages is full, the method waits for a free slot until the context is done. Returns ErrNotConnected
+// if the connection isn't open and the offline queueing is disabled
+func (t *Thing) startPublish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) (mqtt.Token, error) {
+	if err := validatePayloadSize("message", len(payload), t.opts.maxMessageSize); err != nil {
+		return nil, err
+	}
+
+	if !t.opts.offlineQueueing && !t.currentClient().IsConnectionOpen() {
+		return nil, ErrNotConnected
+	}
+
+	if t.inflightSlots != nil {
+		select {
+		case t.inflightSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	token := t.currentClient().Publish(topic, qos, retained, payload)
+
+	t.inflightMu.Lock()
+	t.inflight[token] = struct{}{}
+	t.inflightMu.Unlock()
+
+	go func() {
+		<-token.Done()
+
+		t.inflightMu.Lock()
+		delete(t.inflight, token)
+		t.inflightMu.Unlock()
+
+		if t.inflightSlots != nil {
+			<-t.inflightSlots
+		}
+	}()
+
+	return token, nil
+}
+
+// subscribe adds the handler to the subscription of the topic tracked in the subscriptions registry. The topic is
+// subscribed unless another handler has already subscribed for it. While the thing is disconnected the subscription is
+// only queued and it's sent to the broker once the connection is established
+func (t *Thing) subscribe(topic string, qos byte, handler mqtt.MessageHandler) error {
+	_, err := t.addHandler(topic, qos, handler)
+	return err
+}
+
+// addHandler acts like subscribe but also returns the function removing the handler, which unsubscribes from the topic
+// once no other handler is left, so the temporary handlers never affect the other subscriptions of the same topic
+func (t *Thing) addHandler(topic string, qos byte, handler mqtt.MessageHandler) (remove func() error, err error) {
+	t.connMu.RLock()
+	defer t.connMu.RUnlock()
+
+	// the subscription is queued before checking the connection, so it can't be missed by the connection handler
+	t.mu.Lock()
+	t.handlerSeq++
+	id := t.handlerSeq
+	sub, ok := t.subscriptions[topic]
+	if !ok {
+		sub = &subscription{qos: qos, pending: true}
+		t.subscriptions[topic] = sub
+	}
+	sub.addHandler(id, handler)
+	subscribe := sub.pending
+	t.mu.Unlock()
+
+	remove = func() error {
+		return t.removeHandler(topic, id)
+	}
+
+	if !subscribe || !t.client.IsConnectionOpen() {
+		return remove, nil
+	}
+
+	if err := waitSubscription(t.client.Subscribe(topic, qos, sub.dispatch), topic); err != nil {
+		t.mu.Lock()
+		if sub.removeHandler(id) == 0 && t.subscriptions[topic] == sub {
+			delete(t.subscriptions, topic)
+		}
+		t.mu.Unlock()
+		t.opts.metrics.IncSubscribeError(topic)
+		return nil, fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+	}
+
+	t.mu.Lock()
+	sub.pending = false
+	t.mu.Unlock()
+	return remove, nil
+}
+
+// ErrSubscriptionRejected is returned when the broker refuses the subscription, e.g. as the topic isn't allowed by the
+// policy of the thing
+var ErrSubscriptionRejected = errors.New("the subscription was rejected by the broker")
+
+// waitSubscription waits for the subscription token and checks the return code the broker acknowledged the topic with.
+// AWS IoT acknowledges the subscriptions not allowed by the policy with the failure return code instead of an error
+func waitSubscription(token mqtt.Token, topic string) error {
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	if subscribeToken, ok := token.(*mqtt.SubscribeToken); ok {
+		if code, ok := subscribeToken.Result()[topic]; ok && code == subscriptionFailure {
+			return ErrSubscriptionRejected
+		}
+	}
+	return nil
+}
+
+// subscriptionFailure the SUBACK return code of the refused subscription
+const subscriptionFailure = 0x80
+
+// removeHandler removes the handler with the id from the subscription of the topic and unsubscribes from the topic if
+// it was the last one
+func (t *Thing) removeHandler(topic string, id uint64) error {
+	t.mu.Lock()
+	sub, ok := t.subscriptions[topic]
+	if !ok || sub.removeHandler(id) > 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	delete(t.subscriptions, topic)
+	t.mu.Unlock()
+
+	return t.unsubscribeBroker(topic)
+}
+
+// unsubscribe terminates the MQTT subscription for the provided topics and removes them along with all their handlers
+// from the subscriptions registry
+func (t *Thing) unsubscribe(topics ...string) error {
+	t.mu.Lock()
+	for _, topic := range topics {
+		delete(t.subscriptions, topic)
+	}
+	t.mu.Unlock()
+
+	return t.unsubscribeBroker(topics...)
+}
+
+// unsubscribeBroker terminates the MQTT subscription for the provided topics without touching the registry
+func (t *Thing) unsubscribeBroker(topics ...string) error {
+	t.connMu.RLock()
+	defer t.connMu.RUnlock()
+
+	// the subscriptions queued while disconnected are never sent to the broker, so removing them is enough
+	if !t.client.IsConnectionOpen() {
+		return nil
+	}
+
+	if token := t.client.Unsubscribe(topics...); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to unsubscribe from %s: %w", strings.Join(topics, ", "), token.Error())
+	}
+	return nil
 }
@@ -1,10 +1,14 @@
 package device
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -44,15 +48,39 @@ type shadowStruct struct {
 }
 
 func TestNewThing(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+
+	defer thing.Disconnect()
+}
+
+func TestThing_Connect(t *testing.T) {
 	thing, err := NewThing(keyPair, endpoint, thingName)
 	assert.NoError(t, err, "thing instance created without error")
 	assert.NotNil(t, thing, "thing instance is not nil")
 
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = thing.Connect(ctx)
+	assert.NoError(t, err, "thing connected without error")
+
+	defer thing.Disconnect()
+}
+
+func TestNewThing_WithWill(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName, WithWill("lwt/"+thingName, []byte(`{"online":false}`), 1, false))
+	assert.NoError(t, err, "thing instance with will created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
 	defer thing.Disconnect()
+
+	_, err = NewThingAndConnect(keyPair, endpoint, thingName, WithWill("", []byte(`{"online":false}`), 1, false))
+	assert.Error(t, err, "thing instance with empty will topic is not created")
 }
 
 func TestThingShadow(t *testing.T) {
-	thing, err := NewThing(keyPair, endpoint, thingName)
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
 	assert.NoError(t, err, "thing instance created without error")
 	assert.NotNil(t, thing, "thing instance is not nil")
 	defer thing.Disconnect()
@@ -88,8 +116,50 @@ func TestThingShadow(t *testing.T) {
 	assert.Equal(t, data, unmarshaledGottenShadow.State.Reported.Value, "retrieved thing shadow has consistent data")
 }
 
+func TestThing_WaitForReportedState(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	data := time.Now().Unix()
+
+	go func() {
+		time.Sleep(time.Second)
+		err := thing.UpdateThingShadow(Shadow(fmt.Sprintf(`{"state": {"reported": {"value": %d}}}`, data)))
+		assert.NoError(t, err, "thing shadow updated without error")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = thing.WaitForReportedState(ctx, func(s Shadow) bool {
+		current := &shadowStruct{}
+		return json.Unmarshal(s, current) == nil && current.State.Reported.Value == data
+	})
+	assert.NoError(t, err, "the reported state has been reached")
+}
+
+func TestThing_GetThingShadowWithRequest(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	err = thing.UpdateThingShadow(Shadow(`{"state":{"reported":{"value":1}}}`))
+	assert.NoError(t, err, "thing shadow updated without error")
+
+	s, err := thing.GetThingShadowWithRequest(ShadowGetRequest{ClientToken: "get-request"})
+	assert.NoError(t, err, "retrieved thing shadow without error")
+
+	doc := ShadowDocument{}
+	err = json.Unmarshal(s, &doc)
+	assert.NoError(t, err, "retrieved thing shadow unmarshaled without error")
+	assert.Equal(t, "get-request", doc.ClientToken, "retrieved thing shadow has the request client token")
+}
+
 func TestThing_UpdateThingShadowShouldFail(t *testing.T) {
-	thing, err := NewThing(keyPair, endpoint, thingName)
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
 	assert.NoError(t, err, "thing instance created without error")
 	assert.NotNil(t, thing, "thing instance is not nil")
 	defer thing.Disconnect()
@@ -105,12 +175,12 @@ func TestThing_UpdateThingShadowShouldFail(t *testing.T) {
 }
 
 func TestThing_UpdateThingShadowDocument(t *testing.T) {
-	thing, err := NewThing(keyPair, endpoint, thingName)
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
 	assert.NoError(t, err, "thing instance created without error")
 	assert.NotNil(t, thing, "thing instance is not nil")
 	defer thing.Disconnect()
 
-	shadowChan, err := thing.SubscribeForCustomTopic("shadow/update/documents")
+	shadowChan, _, err := thing.SubscribeForCustomTopic("shadow/update/documents")
 	assert.NoError(t, err, "received thing shadow subscription channel without error")
 
 	shadowDocument := fmt.Sprintf(`{"state": {"reported": {"value": %d}}}`, time.Now().UTC().Unix())
@@ -124,8 +194,32 @@ func TestThing_UpdateThingShadowDocument(t *testing.T) {
 	assert.Equal(t, Shadow(shadowDocument), remoteShadow)
 }
 
+func TestThing_SubscribeForThingShadowDocuments(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	documentsChan, err := thing.SubscribeForThingShadowDocuments()
+	assert.NoError(t, err, "received thing shadow documents subscription channel without error")
+
+	data := time.Now().Unix()
+
+	err = thing.UpdateThingShadow(Shadow(fmt.Sprintf(`{"state": {"reported": {"value": %d}}}`, data)))
+	assert.NoError(t, err, "thing shadow updated without error")
+
+	documents, ok := <-documentsChan
+	assert.True(t, ok, "the shadow documents have been handled successfully")
+
+	current := &shadowStruct{}
+	err = json.Unmarshal([]byte(`{"state":{"reported":`+string(documents.Current.State.Reported)+`}}`), current)
+	assert.NoError(t, err, "current reported state unmarshaled without error")
+	assert.Equal(t, data, current.State.Reported.Value, "current shadow document has consistent data")
+	assert.NotZero(t, documents.Current.Version, "current shadow document has version")
+}
+
 func TestThing_DeleteThingShadow(t *testing.T) {
-	thing, err := NewThing(keyPair, endpoint, thingName)
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
 	assert.NoError(t, err, "thing instance created without error")
 	assert.NotNil(t, thing, "thing instance is not nil")
 	defer thing.Disconnect()
@@ -134,15 +228,77 @@ func TestThing_DeleteThingShadow(t *testing.T) {
 	assert.NoError(t, err, "thing shadow deleted without error")
 }
 
+func TestThing_DeleteThingShadowWithTimeout(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	err = thing.UpdateThingShadow(Shadow(`{"state":{"reported":{"value":1}}}`))
+	assert.NoError(t, err, "thing shadow updated without error")
+
+	err = thing.DeleteThingShadowWithTimeout(5 * time.Second)
+	assert.NoError(t, err, "thing shadow deleted without error")
+
+	err = thing.DeleteThingShadowWithTimeout(5 * time.Second)
+	rejection, ok := err.(*ShadowRejection)
+	assert.True(t, ok, "second delete is rejected with the shadow rejection")
+	assert.True(t, rejection.NotFound(), "second delete is rejected as not found")
+}
+
+func TestThing_GetNamedThingShadow(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	shadowName := "config"
+
+	err = thing.PublishToCustomTopic(Shadow(`{"state":{"reported":{"value":1}}}`), "shadow/name/"+shadowName+"/update")
+	assert.NoError(t, err, "thing named shadow updated without error")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	doc, err := thing.GetNamedThingShadow(ctx, shadowName)
+	assert.NoError(t, err, "retrieved thing named shadow without error")
+	assert.NotZero(t, doc.Version, "retrieved thing named shadow has version")
+	assert.NotEmpty(t, doc.ClientToken, "retrieved thing named shadow has client token")
+}
+
+func TestThing_RotateCredentials(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	customTopic := "rotated"
+
+	shadowChan, _, err := thing.SubscribeForCustomTopic(customTopic)
+	assert.NoError(t, err, "received thing shadow custom topic subscription channel without error")
+
+	err = thing.RotateCredentials(keyPair)
+	assert.NoError(t, err, "thing credentials rotated without error")
+
+	shadowPayload := Shadow(`{"state":{"reported":{"rotated":true}}}`)
+
+	err = thing.PublishToCustomTopic(shadowPayload, customTopic)
+	assert.NoError(t, err, "thing shadow published to custom topic after rotation without error")
+
+	remoteShadow, ok := <-shadowChan
+	assert.True(t, ok, "the subscription has been restored after rotation")
+	assert.Equal(t, shadowPayload, remoteShadow)
+}
+
 func TestThing_CustomTopic(t *testing.T) {
-	thing, err := NewThing(keyPair, endpoint, thingName)
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
 	assert.NoError(t, err, "thing instance created without error")
 	assert.NotNil(t, thing, "thing instance is not nil")
 	defer thing.Disconnect()
 
 	customTopic := "fancy"
 
-	shadowChan, err := thing.SubscribeForCustomTopic(customTopic)
+	shadowChan, _, err := thing.SubscribeForCustomTopic(customTopic)
 	assert.NoError(t, err, "received thing shadow custom topic subscription channel without error")
 
 	shadowPayload := Shadow(`{"state":{"reported":{"yo":true}}}`)
@@ -156,3 +312,727 @@ func TestThing_CustomTopic(t *testing.T) {
 
 	assert.Equal(t, shadowPayload, remoteShadow)
 }
+
+func TestThing_PublishBatch(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	customTopic := "batch"
+
+	shadowChan, _, err := thing.SubscribeForCustomTopic(customTopic)
+	assert.NoError(t, err, "received thing shadow custom topic subscription channel without error")
+
+	messages := []PublishRequest{
+		{Topic: customTopic, Payload: Shadow(`{"value":1}`)},
+		{Topic: customTopic, Payload: Shadow(`{"value":2}`)},
+		{Topic: customTopic, Payload: Shadow(`{"value":3}`)},
+	}
+
+	err = thing.PublishBatch(messages)
+	assert.NoError(t, err, "batch published without error")
+
+	for range messages {
+		_, ok := <-shadowChan
+		assert.True(t, ok, "the batch message has been handled successfully")
+	}
+}
+
+func TestThing_ConcurrentPublishAndSubscribe(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		customTopic := fmt.Sprintf("concurrent/%d", i)
+
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _, err := thing.SubscribeForCustomTopic(customTopic)
+			assert.NoError(t, err, "subscribed to custom topic concurrently without error")
+			err = thing.UnsubscribeFromCustomTopic(customTopic)
+			assert.NoError(t, err, "unsubscribed from custom topic concurrently without error")
+		}()
+		go func() {
+			defer wg.Done()
+			// published to a distinct topic, since nobody reads the subscription channel
+			err := thing.PublishToCustomTopic(Shadow(`{"value":1}`), customTopic+"/publish")
+			assert.NoError(t, err, "published to custom topic concurrently without error")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestThing_DrainAndDisconnect(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+
+	err = thing.UpdateThingShadow(Shadow(`{"state":{"reported":{"draining":true}}}`))
+	assert.NoError(t, err, "thing shadow updated without error")
+
+	err = thing.DrainAndDisconnect(5 * time.Second)
+	assert.NoError(t, err, "thing drained and disconnected without error")
+}
+
+func TestThing_SubscribeRaw(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	topic := "$aws/things/" + thingName + "/raw"
+
+	msgChan, err := thing.SubscribeRaw(topic, 1)
+	assert.NoError(t, err, "received raw subscription channel without error")
+	defer thing.Unsubscribe(topic)
+
+	err = thing.PublishToCustomTopic(Shadow(`{"value":1}`), "raw")
+	assert.NoError(t, err, "published to custom topic without error")
+
+	msg, ok := <-msgChan
+	assert.True(t, ok, "the raw message has been handled successfully")
+	assert.Equal(t, topic, msg.Topic(), "the raw message has the topic")
+	assert.Equal(t, []byte(`{"value":1}`), msg.Payload(), "the raw message has the payload")
+}
+
+func TestThing_SubscribeBeforeConnect(t *testing.T) {
+	thing, err := NewThing(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+
+	shadowChan, _, err := thing.SubscribeForCustomTopic("queued")
+	assert.NoError(t, err, "subscribed for custom topic before connecting without error")
+	defer thing.UnsubscribeFromCustomTopic("queued")
+
+	err = thing.Connect(context.Background())
+	assert.NoError(t, err, "thing connected without error")
+	defer thing.Disconnect()
+
+	// the queued subscription is sent asynchronously once connected
+	time.Sleep(time.Second)
+
+	err = thing.PublishToCustomTopic(Shadow(`{"value":1}`), "queued")
+	assert.NoError(t, err, "published to custom topic without error")
+
+	shadow, ok := <-shadowChan
+	assert.True(t, ok, "the queued subscription message has been handled successfully")
+	assert.Equal(t, Shadow(`{"value":1}`), shadow, "the queued subscription message has the payload")
+}
+
+func TestThing_UpdateThingShadowSync(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accepted, err := thing.UpdateThingShadowSync(ctx, Shadow(`{"state":{"reported":{"synced":true}}}`))
+	assert.NoError(t, err, "thing shadow update accepted without error")
+	assert.Contains(t, accepted.String(), `"synced":true`, "the accepted response contains the update")
+
+	_, err = thing.UpdateThingShadowSync(ctx, Shadow(`{"state":"invalid"}`))
+	assert.Error(t, err, "invalid thing shadow update rejected")
+}
+
+func TestThing_PublishToCustomTopicConfirmed(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err = thing.PublishToCustomTopicConfirmed(ctx, Shadow(`{"value":1}`), "confirmed")
+	assert.NoError(t, err, "published to custom topic with confirmation without error")
+}
+
+func TestThing_GetShadowAndDelta(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = thing.UpdateThingShadowSync(ctx, Shadow(`{"state":{"desired":{"mode":"on"},"reported":{"mode":"off"}}}`))
+	assert.NoError(t, err, "thing shadow updated without error")
+
+	reported, delta, err := thing.GetShadowAndDelta()
+	assert.NoError(t, err, "thing shadow and delta retrieved without error")
+	assert.Contains(t, reported.String(), `"mode":"off"`, "the reported state is returned")
+	assert.Contains(t, delta.String(), `"mode":"on"`, "the delta is returned")
+}
+
+func TestThing_OnCustomTopic(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	payloads := make(chan []byte, 1)
+	err = thing.OnCustomTopic("callback", func(payload []byte) {
+		select {
+		case payloads <- payload:
+		default:
+		}
+	})
+	assert.NoError(t, err, "custom topic handler registered without error")
+	defer thing.UnsubscribeFromCustomTopic("callback")
+
+	err = thing.PublishToCustomTopic(Shadow(`{"value":1}`), "callback")
+	assert.NoError(t, err, "published to custom topic without error")
+
+	select {
+	case payload := <-payloads:
+		assert.Equal(t, []byte(`{"value":1}`), payload, "the handler received the payload")
+	case <-time.After(10 * time.Second):
+		t.Error("the handler hasn't been called")
+	}
+}
+
+func TestThing_ConnectionEvents(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+
+	select {
+	case event := <-thing.ConnectionEvents():
+		assert.Equal(t, Connected, event.State, "the connection event is emitted")
+	case <-time.After(10 * time.Second):
+		t.Error("the connection event hasn't been emitted")
+	}
+
+	thing.Disconnect()
+
+	event := <-thing.ConnectionEvents()
+	assert.Equal(t, Disconnected, event.State, "the disconnection event is emitted")
+	assert.NoError(t, event.Err, "the requested disconnection has no error")
+}
+
+func TestNewThing_WithDefaultQoS(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName, WithDefaultQoS(1))
+	assert.NoError(t, err, "thing instance with default QoS created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	_, err = thing.GetThingShadow()
+	assert.NoError(t, err, "thing shadow retrieved with default QoS without error")
+
+	_, err = NewThing(keyPair, endpoint, thingName, WithDefaultQoS(2))
+	assert.Error(t, err, "thing instance with QoS 2 is not created")
+}
+
+func TestThing_GetThingShadowReader(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	r, err := thing.GetThingShadowReader()
+	assert.NoError(t, err, "thing shadow reader retrieved without error")
+	defer r.Close()
+
+	doc := ShadowDocument{}
+	err = json.NewDecoder(r).Decode(&doc)
+	assert.NoError(t, err, "thing shadow decoded from the reader without error")
+	assert.NotZero(t, doc.Version, "the decoded thing shadow has the version")
+}
+
+func TestThing_PublishAsync(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+
+	token, err := thing.PublishAsync("$aws/things/"+thingName+"/async", []byte(`{"value":1}`), 1)
+	assert.NoError(t, err, "published asynchronously without error")
+
+	err = thing.DrainAndDisconnect(5 * time.Second)
+	assert.NoError(t, err, "thing drained and disconnected without error")
+	assert.NoError(t, token.Error(), "the asynchronous publish completed without error")
+}
+
+func TestThing_UpdateReportedStateWithRetry(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	err = thing.UpdateReportedStateWithRetry(ctx, func(current Shadow) Shadow {
+		return Shadow(`{"counter":1}`)
+	})
+	assert.NoError(t, err, "reported state updated without error")
+
+	reported, _, err := thing.GetShadowAndDelta()
+	assert.NoError(t, err, "thing shadow retrieved without error")
+	assert.Contains(t, reported.String(), `"counter":1`, "the reported state is updated")
+}
+
+func TestNewThingWithCustomAuth(t *testing.T) {
+	thing, err := NewThingWithCustomAuth(endpoint, thingName, "user?x-amz-customauthorizer-name=authorizer", "token", nil)
+	assert.NoError(t, err, "thing instance with custom auth created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+
+	opts := thing.Client().OptionsReader()
+	assert.Equal(t, "user?x-amz-customauthorizer-name=authorizer", opts.Username(), "the username is configured")
+	assert.Equal(t, "token", opts.Password(), "the password is configured")
+	assert.Empty(t, opts.TLSConfig().Certificates, "no client certificates are configured")
+}
+
+func TestThing_ListNamedShadows(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = thing.ListNamedShadows(ctx)
+	assert.NoError(t, err, "named shadows listed without error")
+}
+
+func TestThing_SubscribeForShadowErrors(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	rejectionChan, err := thing.SubscribeForShadowErrors()
+	assert.NoError(t, err, "subscribed for shadow errors without error")
+
+	err = thing.UpdateThingShadow(Shadow("invalid JSON"))
+	assert.NoError(t, err, "invalid thing shadow update published without error")
+
+	select {
+	case r := <-rejectionChan:
+		assert.Equal(t, 400, r.Code, "the rejection has the bad request code")
+	case <-time.After(10 * time.Second):
+		t.Error("the rejection hasn't been received")
+	}
+}
+
+func TestThing_TopicWriter(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	topic := "$aws/things/" + thingName + "/log"
+
+	msgChan, err := thing.SubscribeRaw(topic, 0)
+	assert.NoError(t, err, "received raw subscription channel without error")
+	defer thing.Unsubscribe(topic)
+
+	w := thing.TopicWriter(topic, 0)
+	_, err = w.Write([]byte("first line\nsecond "))
+	assert.NoError(t, err, "written to the topic without error")
+
+	msg := <-msgChan
+	assert.Equal(t, []byte("first line"), msg.Payload(), "the complete line is published")
+
+	_, err = w.Write([]byte("line\n"))
+	assert.NoError(t, err, "written to the topic without error")
+
+	msg = <-msgChan
+	assert.Equal(t, []byte("second line"), msg.Payload(), "the buffered line is published once complete")
+
+	assert.NoError(t, w.Close(), "topic writer closed without error")
+}
+
+func TestThing_ShadowFor(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	// the thing itself is used as the child, since the test policy allows only its own topics
+	c, err := thing.ShadowFor(thingName)
+	assert.NoError(t, err, "shadow client created without error")
+
+	err = c.UpdateThingShadow(Shadow(`{"state":{"reported":{"gateway":true}}}`))
+	assert.NoError(t, err, "child thing shadow updated without error")
+
+	s, err := thing.GetShadowFor(thingName)
+	assert.NoError(t, err, "child thing shadow retrieved without error")
+	assert.NotEmpty(t, s, "child thing shadow is not empty")
+}
+
+func TestThing_SubscribeOnce(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		time.Sleep(time.Second)
+		thing.PublishToCustomTopic(Shadow(`{"value":1}`), "once")
+	}()
+
+	payload, err := thing.SubscribeOnce(ctx, "once")
+	assert.NoError(t, err, "received a single message without error")
+	assert.Equal(t, []byte(`{"value":1}`), payload, "the message has the payload")
+
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Second)
+	defer timeoutCancel()
+
+	_, err = thing.SubscribeOnce(timeoutCtx, "once")
+	assert.Error(t, err, "the context error is returned when no message arrives")
+}
+
+func TestNewThing_WithMaxInflightMessages(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName, WithMaxInflightMessages(1))
+	assert.NoError(t, err, "thing instance with max inflight messages created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	err = thing.PublishBatch([]PublishRequest{
+		{Topic: "inflight", Payload: Shadow(`{"value":1}`), QoS: 1},
+		{Topic: "inflight", Payload: Shadow(`{"value":2}`), QoS: 1},
+		{Topic: "inflight", Payload: Shadow(`{"value":3}`), QoS: 1},
+	})
+	assert.NoError(t, err, "batch exceeding the in-flight window published without error")
+
+	_, err = NewThing(keyPair, endpoint, thingName, WithMaxInflightMessages(0))
+	assert.Error(t, err, "thing instance with zero max inflight messages is not created")
+}
+
+func TestThing_PublishJSON(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	ch, _, err := thing.SubscribeForCustomTopic("json")
+	assert.NoError(t, err, "subscribed to custom topic without error")
+
+	err = thing.PublishJSON("json", map[string]int{"value": 1}, 1)
+	assert.NoError(t, err, "JSON published without error")
+
+	select {
+	case payload := <-ch:
+		assert.JSONEq(t, `{"value":1}`, payload.String(), "the marshaled value is received")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the JSON message is not received")
+	}
+
+	err = thing.PublishJSON("json", make(chan int), 1)
+	var marshalErr *MarshalError
+	assert.True(t, errors.As(err, &marshalErr), "the marshaling error is returned as MarshalError")
+}
+
+func TestSubscribeJSON(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	type command struct {
+		Value int `json:"value"`
+	}
+
+	values, errs, err := SubscribeJSON[command](thing, "typed")
+	assert.NoError(t, err, "subscribed for JSON messages without error")
+
+	err = thing.PublishToCustomTopic(Shadow(`not json`), "typed")
+	assert.NoError(t, err, "malformed message published without error")
+
+	select {
+	case err := <-errs:
+		assert.Error(t, err, "the unmarshaling error is reported")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the unmarshaling error is not reported")
+	}
+
+	err = thing.PublishJSON("typed", command{Value: 1}, 0)
+	assert.NoError(t, err, "JSON published without error")
+
+	select {
+	case v := <-values:
+		assert.Equal(t, command{Value: 1}, v, "the message is unmarshaled after the malformed one")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the JSON message is not received")
+	}
+}
+
+func TestNewThing_WithShadowCache(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName, WithShadowCache())
+	assert.NoError(t, err, "thing instance with shadow cache created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = thing.UpdateThingShadowSync(ctx, Shadow(`{"state":{"reported":{"cached":1}}}`))
+	assert.NoError(t, err, "thing shadow updated without error")
+
+	first, err := thing.GetThingShadow()
+	assert.NoError(t, err, "thing shadow retrieved without error")
+	second, err := thing.GetThingShadow()
+	assert.NoError(t, err, "cached thing shadow retrieved without error")
+	assert.Equal(t, first, second, "the cached shadow is returned")
+
+	_, err = thing.UpdateThingShadowSync(ctx, Shadow(`{"state":{"reported":{"cached":2}}}`))
+	assert.NoError(t, err, "thing shadow updated without error")
+
+	// the invalidating accepted message may arrive slightly after the update response
+	var updated Shadow
+	for i := 0; i < 100 && !strings.Contains(updated.String(), `"cached":2`); i++ {
+		time.Sleep(100 * time.Millisecond)
+		updated, err = thing.GetThingShadow()
+		assert.NoError(t, err, "thing shadow retrieved without error")
+	}
+	assert.Contains(t, updated.String(), `"cached":2`, "the updated shadow is returned after the invalidation")
+}
+
+func TestThing_DeleteThingShadowWithResult(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	updated, err := thing.UpdateThingShadowSync(ctx, Shadow(`{"state":{"reported":{"value":1}}}`))
+	assert.NoError(t, err, "thing shadow updated without error")
+
+	doc := ShadowDocument{}
+	assert.NoError(t, json.Unmarshal(updated, &doc), "update response parsed without error")
+
+	version, err := thing.DeleteThingShadowWithResult()
+	assert.NoError(t, err, "thing shadow deleted without error")
+	assert.Equal(t, doc.Version, version, "the version of the deleted shadow is returned")
+}
+
+func TestThing_SubscribeForAllShadowDeltas(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	deltas, err := thing.SubscribeForAllShadowDeltas("config", "firmware")
+	assert.NoError(t, err, "subscribed for the named shadow deltas without error")
+
+	token, err := thing.PublishAsync(thing.shadowTopic("firmware")+"/update", []byte(`{"state":{"desired":{"version":"1.0.1"},"reported":{"version":"1.0.0"}}}`), 1)
+	assert.NoError(t, err, "named thing shadow update published without error")
+	token.Wait()
+	assert.NoError(t, token.Error(), "named thing shadow update delivered without error")
+
+	select {
+	case delta := <-deltas:
+		assert.Equal(t, "firmware", delta.ShadowName, "the delta carries the shadow name")
+		assert.Contains(t, delta.Payload.String(), `"version":"1.0.1"`, "the delta carries the payload")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the named shadow delta is not received")
+	}
+
+	_, err = thing.SubscribeForAllShadowDeltas("config/+")
+	assert.Error(t, err, "invalid shadow name is rejected")
+}
+
+func TestThing_SubscribeForCustomTopic_Cancel(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	shadowChan, cancel, err := thing.SubscribeForCustomTopic("cancel")
+	assert.NoError(t, err, "subscribed to custom topic without error")
+
+	// nobody reads the message, so the cancel must release the blocked delivery
+	err = thing.PublishToCustomTopic(Shadow(`{"value":1}`), "cancel")
+	assert.NoError(t, err, "published to custom topic without error")
+	time.Sleep(time.Second)
+
+	assert.NoError(t, cancel(), "unsubscribed without error")
+	assert.NoError(t, cancel(), "repeated cancel is a no-op")
+
+	select {
+	case _, ok := <-shadowChan:
+		assert.False(t, ok, "the channel is closed after the cancel")
+	case <-time.After(time.Second):
+		t.Fatal("the channel is not closed after the cancel")
+	}
+}
+
+func TestThing_Reconnect(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	shadowChan, _, err := thing.SubscribeForCustomTopic("reconnect")
+	assert.NoError(t, err, "subscribed to custom topic without error")
+
+	err = thing.Reconnect()
+	assert.NoError(t, err, "thing reconnected without error")
+
+	err = thing.PublishToCustomTopic(Shadow(`{"value":1}`), "reconnect")
+	assert.NoError(t, err, "published to custom topic after the reconnect without error")
+
+	select {
+	case s := <-shadowChan:
+		assert.Equal(t, Shadow(`{"value":1}`), s, "the subscription is restored after the reconnect")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the message is not received after the reconnect")
+	}
+}
+
+func TestThing_Call(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	// the responder echoes the requests, so every call receives its own payload
+	err = thing.OnCustomTopic("rpc/request", func(payload []byte) {
+		go thing.PublishToCustomTopic(payload, "rpc/response")
+	})
+	assert.NoError(t, err, "responder subscribed without error")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			response, err := thing.Call(ctx, "rpc/request", "rpc/response", []byte(fmt.Sprintf(`{"value":%d}`, i)))
+			assert.NoError(t, err, "call completed without error")
+			assert.Contains(t, string(response), fmt.Sprintf(`"value":%d`, i), "the matching response is returned")
+		}(i)
+	}
+	wg.Wait()
+
+	_, err = thing.Call(ctx, "rpc/request", "rpc/response", []byte(`not json`))
+	assert.Error(t, err, "non-object payload is rejected")
+}
+
+func TestNewThing_WithSubscriptionBufferSize(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName, WithSubscriptionBufferSize(2))
+	assert.NoError(t, err, "thing instance with subscription buffer created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	shadowChan, _, err := thing.SubscribeForCustomTopic("buffered")
+	assert.NoError(t, err, "subscribed to custom topic without error")
+	assert.Equal(t, 2, cap(shadowChan), "the subscription channel is buffered")
+
+	_, err = NewThing(keyPair, endpoint, thingName, WithSubscriptionBufferSize(-1))
+	assert.Error(t, err, "thing instance with negative subscription buffer is not created")
+}
+
+func TestThing_GetThingShadow_Concurrent(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	err = thing.UpdateThingShadow(Shadow(`{"state":{"reported":{"value":"concurrent"}}}`))
+	assert.NoError(t, err, "thing shadow updated without error")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			s, err := thing.GetThingShadow()
+			assert.NoError(t, err, "thing shadow received without error")
+			assert.Contains(t, string(s), `"value":"concurrent"`, "every concurrent request receives the shadow")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestThing_PublishSubscribe_Binary(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	payloadChan, cancel, err := thing.Subscribe("binary")
+	assert.NoError(t, err, "subscribed to custom topic without error")
+	defer cancel()
+
+	payload := Payload{0x00, 0xff, 0x10, 0x80}
+	err = thing.Publish("binary", payload)
+	assert.NoError(t, err, "binary payload published without error")
+
+	select {
+	case p := <-payloadChan:
+		assert.Equal(t, payload, p, "the binary payload is received unchanged")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the binary payload is not received")
+	}
+}
+
+func TestThing_SubscriptionSurvivesReconnect(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	payloadChan, cancel, err := thing.Subscribe("survives")
+	assert.NoError(t, err, "subscribed to custom topic without error")
+	defer cancel()
+
+	thing.Disconnect()
+	err = thing.Connect(context.Background())
+	assert.NoError(t, err, "thing connected again without error")
+
+	// the subscription is restored asynchronously once connected
+	time.Sleep(time.Second)
+
+	err = thing.Publish("survives", Payload(`{"value":1}`))
+	assert.NoError(t, err, "published after the reconnect without error")
+
+	select {
+	case p := <-payloadChan:
+		assert.Equal(t, Payload(`{"value":1}`), p, "the same channel keeps delivering after the reconnect")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the message is not received after the reconnect")
+	}
+}
+
+func TestThing_SubscribeForExternalShadowChanges(t *testing.T) {
+	thing, err := NewThingAndConnect(keyPair, endpoint, thingName)
+	assert.NoError(t, err, "thing instance created without error")
+	assert.NotNil(t, thing, "thing instance is not nil")
+	defer thing.Disconnect()
+
+	shadowChan, err := thing.SubscribeForExternalShadowChanges()
+	assert.NoError(t, err, "subscribed to external shadow changes without error")
+
+	err = thing.UpdateThingShadow(Shadow(`{"state":{"reported":{"value":"own"}}}`))
+	assert.NoError(t, err, "own shadow update published without error")
+
+	// the update published without the client token stands for the one made by another client
+	_, err = thing.PublishAsync(ShadowTopic(thingName, "", "update"), []byte(`{"state":{"desired":{"value":"external"}}}`), 1)
+	assert.NoError(t, err, "external shadow update published without error")
+
+	select {
+	case s := <-shadowChan:
+		assert.Contains(t, string(s), `"value":"external"`, "only the external update is received")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the external shadow update is not received")
+	}
+}
@@ -0,0 +1,60 @@
+package device
+
+import "strings"
+
+// ThingTopic returns the topic of the thing reserved by AWS IoT, i.e. "$aws/things/<thing_name>/<suffix>", e.g. to
+// subscribe through the Client directly. The suffix may span multiple topic levels. Returns an empty string if the
+// thing name is invalid or the resulting topic isn't a valid MQTT topic
+func ThingTopic(thingName, suffix string) string {
+	if validateThingName(thingName) != nil {
+		return ""
+	}
+
+	topic := buildTopic(DefaultTopicPrefix, thingName, suffix)
+	if validateTopic(topic, true) != nil {
+		return ""
+	}
+	return topic
+}
+
+// ShadowTopic returns the topic of the shadow operation, e.g. "get/accepted" or "update/delta", of the thing classic
+// shadow if the shadow name is empty or of the thing named shadow otherwise. Returns an empty string if the thing or
+// shadow name is invalid or the resulting topic isn't a valid MQTT topic
+func ShadowTopic(thingName, shadowName, operation string) string {
+	if validateThingName(thingName) != nil {
+		return ""
+	}
+	if shadowName != "" && validateShadowName(shadowName) != nil {
+		return ""
+	}
+
+	topic := buildTopic(DefaultTopicPrefix, thingName, append(shadowLevels(shadowName), operation)...)
+	if validateTopic(topic, true) != nil {
+		return ""
+	}
+	return topic
+}
+
+// buildTopic joins the topic prefix, the thing name and the topic levels dropping the redundant slashes. Unlike
+// path.Join it keeps the "." and ".." levels as is, so that validateTopic rejects them instead of the levels escaping
+// the thing topic
+func buildTopic(prefix, thingName string, levels ...string) string {
+	parts := []string{prefix, thingName}
+	for _, level := range levels {
+		for _, part := range strings.Split(level, "/") {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// shadowLevels returns the topic levels of the classic shadow if the shadow name is empty or of the named shadow
+// otherwise
+func shadowLevels(shadowName string) []string {
+	if shadowName == "" {
+		return []string{"shadow"}
+	}
+	return []string{"shadow", "name", shadowName}
+}
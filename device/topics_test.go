@@ -0,0 +1,33 @@
+package device
+
+import (
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestThingTopic(t *testing.T) {
+	assert.Equal(t, "$aws/things/thing/commands", ThingTopic("thing", "commands"), "the thing topic is built")
+	assert.Equal(t, "$aws/things/thing/jobs/notify", ThingTopic("thing", "/jobs//notify/"), "the redundant slashes are removed")
+	assert.Equal(t, "$aws/things/thing/commands/+", ThingTopic("thing", "commands/+"), "the wildcards are kept")
+	assert.Empty(t, ThingTopic("thing/+", "commands"), "invalid thing name is rejected")
+	assert.Empty(t, ThingTopic("thing", "commands/#/reply"), "invalid wildcard is rejected")
+	assert.Empty(t, ThingTopic("thing", "../../other/x"), "the path traversal is rejected")
+	assert.Empty(t, ThingTopic("thing", "./commands"), "the current level is rejected")
+}
+
+func TestShadowTopic(t *testing.T) {
+	assert.Equal(t, "$aws/things/thing/shadow/get/accepted", ShadowTopic("thing", "", "get/accepted"), "the classic shadow topic is built")
+	assert.Equal(t, "$aws/things/thing/shadow/name/config/update/delta", ShadowTopic("thing", "config", "update/delta"), "the named shadow topic is built")
+	assert.Equal(t, "$aws/things/thing/shadow/name/config", ShadowTopic("thing", "config", ""), "the named shadow topic is built without the operation")
+	assert.Empty(t, ShadowTopic("thing", "config/name", "get"), "invalid shadow name is rejected")
+	assert.Empty(t, ShadowTopic("", "", "get"), "empty thing name is rejected")
+}
+
+func TestThing_CustomTopicTraversal(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{})
+	assert.NoError(t, err, "thing instance created without error")
+
+	assert.Equal(t, "$aws/things/thing/../../other/x", thing.thingTopic("../../other/x"), "the relative levels are kept")
+	assert.Error(t, thing.PublishToCustomTopic(Shadow(`{"value":1}`), "../../other/x"), "the path traversal is rejected")
+}
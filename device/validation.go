@@ -0,0 +1,130 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxTopicLength the maximum length of the MQTT topic accepted by AWS IoT in bytes
+const maxTopicLength = 256
+
+const (
+	// MaxShadowSize the maximum size of the shadow document accepted by AWS IoT in bytes
+	MaxShadowSize = 8 * 1024
+	// MaxMessageSize the maximum size of the MQTT message payload accepted by AWS IoT in bytes
+	MaxMessageSize = 128 * 1024
+)
+
+// ErrPayloadTooLarge is returned when the shadow update or the message payload exceeds the size limit, so it fails
+// locally instead of being rejected by AWS IoT
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+var (
+	// thingNamePattern matches the thing names allowed by AWS IoT
+	thingNamePattern = regexp.MustCompile(`^[a-zA-Z0-9:_-]{1,128}$`)
+	// shadowNamePattern matches the named shadow names allowed by AWS IoT
+	shadowNamePattern = regexp.MustCompile(`^[a-zA-Z0-9:_-]{1,64}$`)
+	// ruleNamePattern matches the topic rule names allowed by AWS IoT
+	ruleNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_]{1,128}$`)
+)
+
+// validateThingName checks the thing name contains only alphanumeric characters, colons, hyphens and underscores and
+// is up to 128 characters long
+func validateThingName(thingName ThingName) error {
+	if !thingNamePattern.MatchString(thingName) {
+		return fmt.Errorf("invalid thing name %q: must be 1-128 characters long and contain only a-z, A-Z, 0-9, ':', '_' and '-'", thingName)
+	}
+	return nil
+}
+
+// validateShadowName checks the named shadow name contains only alphanumeric characters, colons, hyphens and
+// underscores and is up to 64 characters long
+func validateShadowName(shadowName string) error {
+	if !shadowNamePattern.MatchString(shadowName) {
+		return fmt.Errorf("invalid shadow name %q: must be 1-64 characters long and contain only a-z, A-Z, 0-9, ':', '_' and '-'", shadowName)
+	}
+	return nil
+}
+
+// validateRuleName checks the topic rule name contains only alphanumeric characters and underscores and is up to 128
+// characters long
+func validateRuleName(ruleName string) error {
+	if !ruleNamePattern.MatchString(ruleName) {
+		return fmt.Errorf("invalid rule name %q: must be 1-128 characters long and contain only a-z, A-Z, 0-9 and '_'", ruleName)
+	}
+	return nil
+}
+
+// validateTopic checks the topic isn't empty, fits the AWS IoT length limit and has no empty or relative levels. The
+// wildcards are accepted only if allowed and only when they occupy the whole topic level, "#" being the last one
+func validateTopic(topic string, wildcards bool) error {
+	if topic == "" {
+		return fmt.Errorf("invalid topic: must not be empty")
+	}
+	if len(topic) > maxTopicLength {
+		return fmt.Errorf("invalid topic %q: must be up to %d bytes long", topic, maxTopicLength)
+	}
+
+	levels := strings.Split(topic, "/")
+	for i, level := range levels {
+		switch {
+		case level == "":
+			return fmt.Errorf("invalid topic %q: must not contain empty levels", topic)
+		case level == "." || level == "..":
+			return fmt.Errorf("invalid topic %q: must not contain the relative levels '.' and '..'", topic)
+		case !strings.ContainsAny(level, "+#"):
+			continue
+		case !wildcards:
+			return fmt.Errorf("invalid topic %q: must not contain the wildcards '+' and '#'", topic)
+		case level == "+":
+			continue
+		case level == "#" && i == len(levels)-1:
+			continue
+		default:
+			return fmt.Errorf("invalid topic %q: the wildcards must occupy the whole level and '#' must be the last one", topic)
+		}
+	}
+
+	return nil
+}
+
+// ValidateShadow checks the shadow update is a valid JSON object, has the state section which is either an object or
+// null and fits the AWS IoT shadow size limit. Returns the error describing the first violation found, the oversized
+// shadow error matches ErrPayloadTooLarge
+func ValidateShadow(s Shadow) error {
+	if err := validatePayloadSize("shadow", len(s), MaxShadowSize); err != nil {
+		return err
+	}
+	return validateShadowDocument(s)
+}
+
+// validatePayloadSize checks the payload size fits the limit
+func validatePayloadSize(kind string, size, limit int) error {
+	if size > limit {
+		return fmt.Errorf("invalid %s: %w: must be up to %d bytes long, got %d", kind, ErrPayloadTooLarge, limit, size)
+	}
+	return nil
+}
+
+// validateShadowDocument checks the shadow update is a valid JSON object having the state section which is either an
+// object or null
+func validateShadowDocument(s Shadow) error {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(s, &fields); err != nil {
+		return fmt.Errorf("invalid shadow: must be a JSON object: %w", err)
+	}
+
+	state, ok := fields["state"]
+	if !ok {
+		return fmt.Errorf("invalid shadow: must contain the state section")
+	}
+	if state = bytes.TrimSpace(state); !bytes.Equal(state, []byte("null")) && (len(state) == 0 || state[0] != '{') {
+		return fmt.Errorf("invalid shadow: the state section must be a JSON object or null")
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package device
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestValidateThingName(t *testing.T) {
+	assert.NoError(t, validateThingName("my-thing_01:a"), "valid thing name is accepted")
+	assert.Error(t, validateThingName(""), "empty thing name is rejected")
+	assert.Error(t, validateThingName("my/thing"), "thing name with slash is rejected")
+	assert.Error(t, validateThingName("my+thing"), "thing name with wildcard is rejected")
+	assert.Error(t, validateThingName(strings.Repeat("a", 129)), "too long thing name is rejected")
+}
+
+func TestValidateShadowName(t *testing.T) {
+	assert.NoError(t, validateShadowName("config"), "valid shadow name is accepted")
+	assert.Error(t, validateShadowName("con/fig"), "shadow name with slash is rejected")
+	assert.Error(t, validateShadowName(strings.Repeat("a", 65)), "too long shadow name is rejected")
+}
+
+func TestValidateTopic(t *testing.T) {
+	assert.NoError(t, validateTopic("$aws/things/thing/fancy", false), "valid topic is accepted")
+	assert.Error(t, validateTopic("", false), "empty topic is rejected")
+	assert.Error(t, validateTopic("$aws/things/thing//fancy", false), "topic with empty level is rejected")
+	assert.Error(t, validateTopic("$aws/things/thing/+", false), "publish topic with wildcard is rejected")
+	assert.Error(t, validateTopic("$aws/things/thing/../other", false), "topic with relative level is rejected")
+	assert.Error(t, validateTopic("$aws/things/"+strings.Repeat("a", 256), false), "too long topic is rejected")
+
+	assert.NoError(t, validateTopic("$aws/things/thing/+/fancy/#", true), "subscribe topic with wildcards is accepted")
+	assert.Error(t, validateTopic("$aws/things/thing/#/fancy", true), "subscribe topic with non-trailing # is rejected")
+	assert.Error(t, validateTopic("$aws/things/thing/fan+cy", true), "subscribe topic with partial level wildcard is rejected")
+}
+
+func TestValidateShadow(t *testing.T) {
+	assert.NoError(t, ValidateShadow(Shadow(`{"state":{"reported":{"value":1}}}`)), "valid shadow is accepted")
+	assert.NoError(t, ValidateShadow(Shadow(`{"state":null}`)), "shadow with null state is accepted")
+	assert.Error(t, ValidateShadow(Shadow("invalid JSON")), "invalid JSON is rejected")
+	assert.Error(t, ValidateShadow(Shadow(`{"reported":{"value":1}}`)), "shadow without state is rejected")
+	assert.Error(t, ValidateShadow(Shadow(`{"state":"invalid"}`)), "shadow with non-object state is rejected")
+
+	large := `{"state":{"reported":{"value":"` + strings.Repeat("a", MaxShadowSize) + `"}}}`
+	assert.True(t, errors.Is(ValidateShadow(Shadow(large)), ErrPayloadTooLarge), "too large shadow is rejected")
+}
+
+func TestValidateRuleName(t *testing.T) {
+	assert.NoError(t, validateRuleName("telemetry_rule"), "valid rule name is accepted")
+	assert.Error(t, validateRuleName(""), "empty rule name is rejected")
+	assert.Error(t, validateRuleName("telemetry-rule"), "rule name with hyphen is rejected")
+}
+
+func TestThing_PayloadLimits(t *testing.T) {
+	thing, err := newThing("example-ats.iot.us-east-1.amazonaws.com", "thing", &tls.Config{}, WithPayloadLimits(16, 32))
+	assert.NoError(t, err, "thing instance with payload limits created without error")
+
+	assert.NoError(t, thing.validateShadow(Shadow(`{"state":{}}`)), "shadow within the limit is accepted")
+	err = thing.UpdateThingShadow(Shadow(`{"state":{"reported":{}}}`))
+	assert.True(t, errors.Is(err, ErrPayloadTooLarge), "shadow over the limit is rejected locally")
+
+	_, err = thing.PublishAsync("topic", []byte(strings.Repeat("a", 33)), 0)
+	assert.True(t, errors.Is(err, ErrPayloadTooLarge), "message over the limit is rejected locally")
+
+	assert.Error(t, WithPayloadLimits(0, 1)(defaultOptions()), "zero shadow limit is rejected")
+}
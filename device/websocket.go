@@ -0,0 +1,150 @@
+package device
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWSCredentials the AWS credentials signing the WebSocket connection URL
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// CredentialsProvider returns the current AWS credentials. It's called before every connection attempt, so the
+// provider should cache the credentials, e.g. with the credentials.CachingProvider
+type CredentialsProvider func() (AWSCredentials, error)
+
+// sigV4Signer presigns the WebSocket connection URL
+type sigV4Signer struct {
+	region   string
+	provider CredentialsProvider
+
+	// err is the error of the latest presign, so the failed connection reports it instead of the rejected handshake
+	mu  sync.Mutex
+	err error
+}
+
+// NewThingWithWebSocket returns a new instance of Thing connecting to the AWS IoT endpoint over the WebSocket with the
+// connection URL signed with the AWS Signature Version 4. The URL is presigned with the credentials returned by the
+// provider before every connection attempt, so the reconnections keep working after the previous presigned URL or
+// the credentials expire. In case the credentials can't be retrieved the attempt is made with the previous URL and is
+// likely to fail. The region is detected from the endpoint if empty. The returned thing isn't connected, the Connect
+// method must be called to establish the MQTT session. WithProxy isn't supported for the WebSocket connections
+func NewThingWithWebSocket(awsEndpoint string, thingName ThingName, region string, provider CredentialsProvider, opts ...Option) (*Thing, error) {
+	if err := validateThingName(thingName); err != nil {
+		return nil, err
+	}
+	if provider == nil {
+		return nil, errors.New("credentials provider must not be nil")
+	}
+
+	if region == "" {
+		var err error
+		if region, err = RegionFromEndpoint(awsEndpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	return newThing(awsEndpoint, thingName, &tls.Config{}, append(opts, func(o *options) error {
+		if o.proxyURL != nil {
+			return errors.New("proxy isn't supported for the WebSocket connections")
+		}
+
+		o.sigV4 = &sigV4Signer{
+			region:   region,
+			provider: provider,
+		}
+		return nil
+	})...)
+}
+
+// presign replaces the query of the WebSocket connection URL with the fresh signature. The URL is kept on error
+func (s *sigV4Signer) presign(broker *url.URL, now time.Time) error {
+	creds, err := s.provider()
+	if err != nil {
+		err = fmt.Errorf("failed to retrieve the AWS credentials: %w", err)
+	} else {
+		broker.RawQuery = presignQuery(broker.Hostname(), broker.Path, s.region, creds, now)
+	}
+
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+	return err
+}
+
+// lastError returns the error of the latest presign, nil if it succeeded
+func (s *sigV4Signer) lastError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// presignQuery returns the query of the WebSocket connection URL signed with the AWS Signature Version 4. AWS IoT
+// expects the session token to be appended after the signature instead of being signed
+func presignQuery(host, path, region string, creds AWSCredentials, now time.Time) string {
+	const (
+		algorithm = "AWS4-HMAC-SHA256"
+		service   = "iotdevicegateway"
+	)
+
+	now = now.UTC()
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+
+	// the parameters are sorted by name as required by the canonical request
+	query := strings.Join([]string{
+		"X-Amz-Algorithm=" + algorithm,
+		"X-Amz-Credential=" + url.QueryEscape(creds.AccessKeyID+"/"+scope),
+		"X-Amz-Date=" + amzDate,
+		"X-Amz-SignedHeaders=host",
+	}, "&")
+
+	emptyPayloadHash := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		path,
+		query,
+		"host:" + host + "\n",
+		"host",
+		hex.EncodeToString(emptyPayloadHash[:]),
+	}, "\n")
+
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	query += "&X-Amz-Signature=" + signature
+	if creds.SessionToken != "" {
+		query += "&X-Amz-Security-Token=" + url.QueryEscape(creds.SessionToken)
+	}
+	return query
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the data with the key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,53 @@
+package device
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresignQuery(t *testing.T) {
+	creds := AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "token/+="}
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	query, err := url.ParseQuery(presignQuery("example.iot.us-east-1.amazonaws.com", "/mqtt", "us-east-1", creds, now))
+	assert.NoError(t, err, "presigned query parsed without error")
+	assert.Equal(t, "AWS4-HMAC-SHA256", query.Get("X-Amz-Algorithm"))
+	assert.Equal(t, "AKID/20200102/us-east-1/iotdevicegateway/aws4_request", query.Get("X-Amz-Credential"))
+	assert.Equal(t, "20200102T030405Z", query.Get("X-Amz-Date"))
+	assert.Equal(t, "host", query.Get("X-Amz-SignedHeaders"))
+	assert.Len(t, query.Get("X-Amz-Signature"), 64, "the signature is the hex encoded SHA256")
+	assert.Equal(t, "token/+=", query.Get("X-Amz-Security-Token"), "the session token is appended")
+
+	later := presignQuery("example.iot.us-east-1.amazonaws.com", "/mqtt", "us-east-1", creds, now.Add(time.Hour))
+	laterQuery, _ := url.ParseQuery(later)
+	assert.NotEqual(t, query.Get("X-Amz-Signature"), laterQuery.Get("X-Amz-Signature"), "the signature depends on the time")
+
+	creds.SessionToken = ""
+	query, _ = url.ParseQuery(presignQuery("example.iot.us-east-1.amazonaws.com", "/mqtt", "us-east-1", creds, now))
+	_, ok := query["X-Amz-Security-Token"]
+	assert.False(t, ok, "the session token is omitted when empty")
+}
+
+func TestSigV4Signer_Presign(t *testing.T) {
+	calls := 0
+	s := &sigV4Signer{region: "us-east-1", provider: func() (AWSCredentials, error) {
+		calls++
+		if calls > 1 {
+			return AWSCredentials{}, errors.New("unavailable")
+		}
+		return AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"}, nil
+	}}
+
+	broker, _ := url.Parse("wss://example.iot.us-east-1.amazonaws.com:443/mqtt")
+	assert.NoError(t, s.presign(broker, time.Now()), "URL presigned without error")
+	assert.Contains(t, broker.RawQuery, "X-Amz-Signature=", "the URL is signed")
+
+	signed := broker.RawQuery
+	assert.Error(t, s.presign(broker, time.Now()), "credentials error is returned")
+	assert.Equal(t, signed, broker.RawQuery, "the previous URL is kept on error")
+	assert.Error(t, s.lastError(), "the presign error is kept")
+}
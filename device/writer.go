@@ -0,0 +1,68 @@
+package device
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// topicWriter publishes every line written to it as a separate message
+type topicWriter struct {
+	thing *Thing
+	topic string
+	qos   byte
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// TopicWriter returns the writer publishing every newline-delimited line written to it as a separate message to the
+// topic, e.g. to ship the log.Logger output. The line is published without the trailing newline once it's complete, so
+// the partial writes are buffered. Close publishes the remaining incomplete line. Unlike the custom topic methods, the
+// topic is used as is without any prefix
+func (t *Thing) TopicWriter(topic string, qos byte) io.WriteCloser {
+	return &topicWriter{
+		thing: t,
+		topic: topic,
+		qos:   qos,
+	}
+}
+
+// Write buffers the data and publishes all the complete lines
+func (w *topicWriter) Write(p []byte) (int, error) {
+	if err := validateTopic(w.topic, false); err != nil {
+		return 0, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+
+		line := make([]byte, i)
+		copy(line, w.buf.Next(i + 1))
+		if err := w.thing.publish(w.topic, w.qos, false, line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+// Close publishes the remaining incomplete line if any
+func (w *topicWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() == 0 {
+		return nil
+	}
+
+	line := make([]byte, w.buf.Len())
+	copy(line, w.buf.Bytes())
+	w.buf.Reset()
+	return w.thing.publish(w.topic, w.qos, false, line)
+}
@@ -1,7 +1,16 @@
 module github.com/kuzemkon/aws-iot-device-sdk-go
 
+go 1.18
+
 require (
-	github.com/eclipse/paho.mqtt.golang v1.1.1
+	github.com/eclipse/paho.mqtt.golang v1.4.1
 	github.com/stretchr/testify v1.3.0
-	golang.org/x/net v0.0.0-20181220203305-927f97764cc3 // indirect
+	golang.org/x/net v0.0.0-20220127200216-cd36cc0744dd
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/gorilla/websocket v1.4.2 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 )
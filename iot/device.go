@@ -0,0 +1,76 @@
+// Package iot combines the MQTT thing and the AWS credentials provider sharing the same device certificate
+package iot
+
+import (
+	"errors"
+
+	"github.com/kuzemkon/aws-iot-device-sdk-go/credentials"
+	"github.com/kuzemkon/aws-iot-device-sdk-go/device"
+)
+
+// Device the AWS IoT device exposing both the thing shadow methods and the AWS credentials retrieval. The device
+// certificate is loaded once and shared by the MQTT connection and the credentials requests
+type Device struct {
+	*device.Thing
+
+	credentials credentials.Service
+}
+
+// NewDevice returns a new instance of Device connected to the AWS IoT endpoint. The iotCredentialsURL parameter should
+// satisfy the pattern described in credentials.NewService
+func NewDevice(keyPair device.KeyPair, awsEndpoint, iotCredentialsURL string, thingName device.ThingName, opts ...device.Option) (*Device, error) {
+	thing, err := device.NewThingAndConnect(keyPair, awsEndpoint, thingName, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	clientOpts := thing.Client().OptionsReader()
+	tlsConfig := clientOpts.TLSConfig()
+	if tlsConfig == nil || len(tlsConfig.Certificates) == 0 {
+		thing.Disconnect()
+		return nil, errors.New("the thing has no device certificate")
+	}
+
+	return &Device{
+		Thing:       thing,
+		credentials: credentials.NewServiceWithCertificate(iotCredentialsURL, tlsConfig.Certificates[0], thingName),
+	}, nil
+}
+
+// GetAWSCredentials returns the AWS credentials retrieved with the device certificate
+func (d *Device) GetAWSCredentials() (credentials.Output, error) {
+	return d.credentials.GetCredentials()
+}
+
+// RotateCredentials reconnects the thing using the new device certificates like device.Thing.RotateCredentials and
+// then reloads them into the credentials service, so both keep sharing the same certificate. The credentials service
+// keeps the previous certificate if the reconnection fails
+func (d *Device) RotateCredentials(newKeyPair device.KeyPair) error {
+	if err := d.Thing.RotateCredentials(newKeyPair); err != nil {
+		return err
+	}
+	return d.credentials.ReloadCertificate(newKeyPair.CertificatePath, newKeyPair.PrivateKeyPath)
+}
+
+// CredentialsService returns the credentials Service of the device, e.g. to be wrapped with the caching provider
+func (d *Device) CredentialsService() credentials.Service {
+	return d.credentials
+}
+
+// NewCredentialsProvider returns the device.CredentialsProvider signing the WebSocket connections of the things created
+// with device.NewThingWithWebSocket. The credentials are retrieved with the service through the caching provider, so
+// every reconnection is signed with the valid credentials without requesting them each time
+func NewCredentialsProvider(p *credentials.CachingProvider, s credentials.Service) device.CredentialsProvider {
+	return func() (device.AWSCredentials, error) {
+		out, err := p.GetCredentials(s)
+		if err != nil {
+			return device.AWSCredentials{}, err
+		}
+
+		return device.AWSCredentials{
+			AccessKeyID:     out.AccessKeyId,
+			SecretAccessKey: out.SecretAccessKey,
+			SessionToken:    out.SessionToken,
+		}, nil
+	}
+}
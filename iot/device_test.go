@@ -0,0 +1,74 @@
+package iot
+
+import (
+	"github.com/kuzemkon/aws-iot-device-sdk-go/device"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+var thingName = ""
+var endpoint = ""
+var credentialsURL = ""
+
+func TestMain(m *testing.M) {
+	var ok bool
+
+	thingName, ok = os.LookupEnv("AWS_IOT_THING_NAME")
+	if !ok {
+		panic("AWS_IOT_THING_NAME environment variable must be defined")
+	}
+
+	endpoint, ok = os.LookupEnv("AWS_MQTT_ENDPOINT")
+	if !ok {
+		panic("AWS_MQTT_ENDPOINT environment variable must be defined")
+	}
+
+	credentialsURL, ok = os.LookupEnv("AWS_IOT_CREDENTIALS_URL")
+	if !ok {
+		panic("AWS_IOT_CREDENTIALS_URL environment variable must be defined")
+	}
+
+	code := m.Run()
+	os.Exit(code)
+}
+
+var keyPair = device.KeyPair{
+	CertificatePath:   "../device/certificates/cert.pem",
+	PrivateKeyPath:    "../device/certificates/private.key",
+	CACertificatePath: "../device/certificates/root.ca.pem",
+}
+
+func TestNewDevice(t *testing.T) {
+	d, err := NewDevice(keyPair, endpoint, credentialsURL, thingName)
+	assert.NoError(t, err, "device instance created without error")
+	assert.NotNil(t, d, "device instance is not nil")
+	defer d.Disconnect()
+
+	_, err = d.GetThingShadow()
+	assert.NoError(t, err, "thing shadow retrieved without error")
+
+	out, err := d.GetAWSCredentials()
+	assert.NoError(t, err, "credentials retrieved without error")
+	assert.NotEmpty(t, out.AccessKeyId, "the retrieved accessKeyId is not empty")
+}
+
+func TestDevice_RotateCredentials(t *testing.T) {
+	d, err := NewDevice(keyPair, endpoint, credentialsURL, thingName)
+	assert.NoError(t, err, "device instance created without error")
+	defer d.Disconnect()
+
+	err = d.RotateCredentials(keyPair)
+	assert.NoError(t, err, "credentials rotated without error")
+
+	out, err := d.GetAWSCredentials()
+	assert.NoError(t, err, "credentials retrieved with the rotated certificate without error")
+	assert.NotEmpty(t, out.AccessKeyId, "the retrieved accessKeyId is not empty")
+
+	err = d.RotateCredentials(device.KeyPair{CertificatePath: "invalid", PrivateKeyPath: "invalid"})
+	assert.Error(t, err, "invalid certificate is rejected")
+
+	out, err = d.GetAWSCredentials()
+	assert.NoError(t, err, "the previous certificate is kept after the failed rotation")
+	assert.NotEmpty(t, out.AccessKeyId, "the retrieved accessKeyId is not empty")
+}
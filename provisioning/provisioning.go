@@ -0,0 +1,195 @@
+// Package provisioning implements the AWS IoT fleet provisioning by claim, which exchanges the claim certificate shared
+// by the fleet for the unique device certificate and registers the thing
+//
+// More info here: https://docs.aws.amazon.com/iot/latest/developerguide/provision-wo-cert.html
+package provisioning
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/kuzemkon/aws-iot-device-sdk-go/device"
+)
+
+// Timeout limits the time of waiting for each of the provisioning responses
+var Timeout = 30 * time.Second
+
+// createCertificateTopic the topic of the request creating the new device certificate and private key
+const createCertificateTopic = "$aws/certificates/create/json"
+
+// createCertificateResponse the accepted response of the certificate creation
+type createCertificateResponse struct {
+	CertificateID             string `json:"certificateId"`
+	CertificatePEM            string `json:"certificatePem"`
+	PrivateKey                string `json:"privateKey"`
+	CertificateOwnershipToken string `json:"certificateOwnershipToken"`
+}
+
+// provisionRequest the body of the provisioning template request
+type provisionRequest struct {
+	CertificateOwnershipToken string            `json:"certificateOwnershipToken"`
+	Parameters                map[string]string `json:"parameters,omitempty"`
+}
+
+// provisionResponse the accepted response of the provisioning template request
+type provisionResponse struct {
+	ThingName           string                 `json:"thingName"`
+	DeviceConfiguration map[string]interface{} `json:"deviceConfiguration"`
+}
+
+// RejectedError the error response published by AWS IoT to the provisioning rejected topics
+type RejectedError struct {
+	StatusCode   int    `json:"statusCode"`
+	ErrorCode    string `json:"errorCode"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+// Error returns the rejection status, code and message
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("provisioning request rejected with status %d (%s): %s", e.StatusCode, e.ErrorCode, e.ErrorMessage)
+}
+
+// ProvisionByClaim connects to the AWS IoT endpoint with the claim certificate, creates the new device certificate and
+// registers the thing with the provisioning template and its parameters. The new certificate and private key are
+// written next to the claim certificate as "<certificate_id>-certificate.pem.crt" and
+// "<certificate_id>-private.pem.key". Returns the key pair of the new certificate, sharing the root CA certificate with
+// the claim one, and the name of the registered thing. Note that the claim certificate policy must allow connecting
+// with the random client ID prefixed by "provisioning-"
+func ProvisionByClaim(claimKeyPair device.KeyPair, endpoint, templateName string, params map[string]string) (device.KeyPair, string, error) {
+	clientID, err := newClientID()
+	if err != nil {
+		return device.KeyPair{}, "", err
+	}
+
+	thing, err := device.NewThingAndConnect(claimKeyPair, endpoint, clientID)
+	if err != nil {
+		return device.KeyPair{}, "", err
+	}
+	defer thing.Disconnect()
+
+	created := createCertificateResponse{}
+	if err := request(thing, createCertificateTopic, struct{}{}, &created); err != nil {
+		return device.KeyPair{}, "", fmt.Errorf("failed to create the certificate: %w", err)
+	}
+
+	provisioned := provisionResponse{}
+	provisionTopic := fmt.Sprintf("$aws/provisioning-templates/%s/provision/json", templateName)
+	if err := request(thing, provisionTopic, provisionRequest{
+		CertificateOwnershipToken: created.CertificateOwnershipToken,
+		Parameters:                params,
+	}, &provisioned); err != nil {
+		return device.KeyPair{}, "", fmt.Errorf("failed to provision the thing: %w", err)
+	}
+
+	keyPair, err := writeKeyPair(filepath.Dir(claimKeyPair.CertificatePath), created)
+	if err != nil {
+		return device.KeyPair{}, "", err
+	}
+	keyPair.CACertificatePath = claimKeyPair.CACertificatePath
+
+	return keyPair, provisioned.ThingName, nil
+}
+
+// newClientID generates a random client ID for the provisioning connection
+func newClientID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate the client ID: %w", err)
+	}
+	return "provisioning-" + hex.EncodeToString(b), nil
+}
+
+// request publishes the request to the topic and waits for the response on the corresponding accepted or rejected
+// topics. The accepted response is parsed into the result, the rejected one is returned as the RejectedError
+func request(thing *device.Thing, topic string, req, result interface{}) error {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the request: %w", err)
+	}
+
+	acceptedChan := make(chan mqtt.Message, 1)
+	if err := subscribeResponse(thing, topic+"/accepted", acceptedChan); err != nil {
+		return err
+	}
+	defer thing.Client().Unsubscribe(topic + "/accepted")
+
+	rejectedChan := make(chan mqtt.Message, 1)
+	if err := subscribeResponse(thing, topic+"/rejected", rejectedChan); err != nil {
+		return err
+	}
+	defer thing.Client().Unsubscribe(topic + "/rejected")
+
+	if token := thing.Client().Publish(topic, 1, false, payload); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, token.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	select {
+	case msg := <-acceptedChan:
+		if err := json.Unmarshal(msg.Payload(), result); err != nil {
+			return fmt.Errorf("failed to parse the response: %w", err)
+		}
+		return nil
+	case msg := <-rejectedChan:
+		rejected := &RejectedError{}
+		if err := json.Unmarshal(msg.Payload(), rejected); err != nil {
+			return fmt.Errorf("request rejected: %s", msg.Payload())
+		}
+		return rejected
+	case <-ctx.Done():
+		return fmt.Errorf("failed to wait for the %s response: %w", topic, ctx.Err())
+	}
+}
+
+// subscribeResponse subscribes for the response topic of the request passing the first response to the buffered
+// channel. The duplicate and late responses are dropped, so they don't block the MQTT client once the request is done
+func subscribeResponse(thing *device.Thing, topic string, responses chan<- mqtt.Message) error {
+	token := thing.Client().Subscribe(topic, 1, responseHandler(responses))
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, token.Error())
+	}
+	if subscribeToken, ok := token.(*mqtt.SubscribeToken); ok && subscribeToken.Result()[topic] == subscriptionFailure {
+		return fmt.Errorf("failed to subscribe to %s: %w", topic, device.ErrSubscriptionRejected)
+	}
+	return nil
+}
+
+// subscriptionFailure the SUBACK return code of the refused subscription
+const subscriptionFailure = 0x80
+
+// responseHandler returns the MQTT message handler sending the messages to the channel without blocking, the messages
+// not fitting the channel buffer are dropped
+func responseHandler(responses chan<- mqtt.Message) mqtt.MessageHandler {
+	return func(_ mqtt.Client, msg mqtt.Message) {
+		select {
+		case responses <- msg:
+		default:
+		}
+	}
+}
+
+// writeKeyPair writes the created certificate and private key into the directory and returns their paths
+func writeKeyPair(dir string, created createCertificateResponse) (device.KeyPair, error) {
+	keyPair := device.KeyPair{
+		CertificatePath: filepath.Join(dir, created.CertificateID+"-certificate.pem.crt"),
+		PrivateKeyPath:  filepath.Join(dir, created.CertificateID+"-private.pem.key"),
+	}
+
+	if err := ioutil.WriteFile(keyPair.CertificatePath, []byte(created.CertificatePEM), 0644); err != nil {
+		return device.KeyPair{}, fmt.Errorf("failed to write the certificate: %w", err)
+	}
+	if err := ioutil.WriteFile(keyPair.PrivateKeyPath, []byte(created.PrivateKey), 0600); err != nil {
+		return device.KeyPair{}, fmt.Errorf("failed to write the private key: %w", err)
+	}
+
+	return keyPair, nil
+}
@@ -0,0 +1,61 @@
+package provisioning
+
+import (
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteKeyPair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provisioning")
+	assert.NoError(t, err, "temporary directory created without error")
+	defer os.RemoveAll(dir)
+
+	keyPair, err := writeKeyPair(dir, createCertificateResponse{
+		CertificateID:  "abc123",
+		CertificatePEM: "certificate",
+		PrivateKey:     "private key",
+	})
+	assert.NoError(t, err, "key pair written without error")
+	assert.Equal(t, filepath.Join(dir, "abc123-certificate.pem.crt"), keyPair.CertificatePath, "the certificate path follows the AWS layout")
+	assert.Equal(t, filepath.Join(dir, "abc123-private.pem.key"), keyPair.PrivateKeyPath, "the private key path follows the AWS layout")
+
+	privateKey, err := ioutil.ReadFile(keyPair.PrivateKeyPath)
+	assert.NoError(t, err, "private key read without error")
+	assert.Equal(t, "private key", string(privateKey), "the private key is written")
+}
+
+// response the MQTT message of the provisioning response
+type response struct {
+	mqtt.Message
+	payload string
+}
+
+func (r response) Payload() []byte {
+	return []byte(r.payload)
+}
+
+func TestResponseHandler_Duplicate(t *testing.T) {
+	responses := make(chan mqtt.Message, 1)
+	handler := responseHandler(responses)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler(nil, response{payload: "first"})
+		handler(nil, response{payload: "duplicate"})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the duplicate response blocked the handler")
+	}
+
+	assert.Equal(t, "first", string((<-responses).Payload()), "the first response is kept")
+	assert.Len(t, responses, 0, "the duplicate response is dropped")
+}